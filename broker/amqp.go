@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"encoding/binary"
+)
+
+const amqpHeaderLen = 8
+
+// Parse an AMQP protocol header, "AMQP" followed by either 0 0 9 1 for
+// 0-9-1, or the layer id and 1 0 0 for 1.0.  The container-id of a 1.0 open
+// frame sent along with the header is picked up when complete, but never
+// waited for.
+func parseAMQP(b []byte) (*Hello, int, error) {
+	const magic = "AMQP"
+	n := len(b)
+	if n > len(magic) {
+		n = len(magic)
+	}
+	if string(b[:n]) != magic[:n] {
+		return nil, 0, errMalformed
+	}
+	if len(b) < amqpHeaderLen {
+		// The version bytes may still rule the header out
+		return nil, len(b) + 1, ErrIncomplete
+	}
+
+	h := &Hello{Protocol: AMQP}
+	switch v := b[4:amqpHeaderLen]; {
+	case v[0] == 0 && v[1] == 0 && v[2] == 9 && v[3] == 1:
+		h.Version = "0-9-1"
+	case v[1] == 1 && v[2] == 0 && v[3] == 0:
+		h.Version = "1.0"
+		switch v[0] {
+		case 0:
+			h.Layer = "amqp"
+			h.ClientID = amqpContainerID(b[amqpHeaderLen:])
+		case 2:
+			h.Layer = "tls"
+		case 3:
+			h.Layer = "sasl"
+		default:
+			return nil, 0, errMalformed
+		}
+	default:
+		return nil, 0, errMalformed
+	}
+	return h, 0, nil
+}
+
+// Container-id of an AMQP 1.0 open frame, empty when the frame is not
+// complete or not an open.
+func amqpContainerID(b []byte) string {
+	if len(b) < 8 {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(b))
+	doff := int(b[4]) * 4
+	if size > len(b) || doff < 8 || doff > size || b[5] != 0 {
+		return ""
+	}
+	body := b[doff:size]
+
+	// Described type with the open descriptor, code 0x10 or its symbol
+	if len(body) < 1 || body[0] != 0 {
+		return ""
+	}
+	body = body[1:]
+	switch {
+	case len(body) >= 2 && body[0] == 0x53 && body[1] == 0x10:
+		body = body[2:]
+	case len(body) >= 9 && body[0] == 0x80 && binary.BigEndian.Uint64(body[1:]) == 0x10:
+		body = body[9:]
+	case len(body) >= 2 && body[0] == 0xa3 && int(body[1]) <= len(body)-2 &&
+		string(body[2:2+int(body[1])]) == "amqp:open:list":
+		body = body[2+int(body[1]):]
+	default:
+		return ""
+	}
+
+	// List of the open fields, the first is the container-id
+	switch {
+	case len(body) >= 3 && body[0] == 0xc0:
+		body = body[3:]
+	case len(body) >= 9 && body[0] == 0xd0:
+		body = body[9:]
+	default:
+		return ""
+	}
+	switch {
+	case len(body) >= 2 && body[0] == 0xa1 && int(body[1]) <= len(body)-2:
+		return string(body[2 : 2+int(body[1])])
+	case len(body) >= 5 && body[0] == 0xb1:
+		n := binary.BigEndian.Uint32(body[1:])
+		if uint64(n) <= uint64(len(body)-5) {
+			return string(body[5 : 5+int(n)])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+/*
+Package broker detects the clients of the AMQP, Kafka and NATS message
+brokers in the teaser buffer, and reports their protocol version and client
+identifier so that each can be routed to its broker cluster.
+
+AMQP and Kafka clients speak first.  NATS clients wait for the INFO line of
+the server, so they land on the Mux silence route, where NATSRouter greets
+them before their CONNECT can be detected.
+*/
+package broker
+
+import (
+	"errors"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	// Returned by Parse while more bytes are needed
+	ErrIncomplete = errors.New("broker: incomplete request")
+	errMalformed  = errors.New("broker: not a message broker client")
+)
+
+// Protocol is the message broker protocol spoken by a client.
+type Protocol int
+
+const (
+	AMQP Protocol = iota
+	Kafka
+	NATS
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case AMQP:
+		return "AMQP"
+	case Kafka:
+		return "Kafka"
+	case NATS:
+		return "NATS"
+	}
+	return "unknown"
+}
+
+// Hello is the start of a message broker client connection.
+type Hello struct {
+	Protocol Protocol
+
+	// Protocol version: "0-9-1" or "1.0" for AMQP, the version of the first
+	// request for Kafka, and the CONNECT protocol number for NATS
+	Version string
+
+	// AMQP 1.0 container-id when the open frame was sent along with the
+	// header, the Kafka client.id, or the NATS client name
+	ClientID string
+
+	// Layer announced by an AMQP 1.0 header: "amqp", "tls" or "sasl"
+	Layer string
+
+	// API key of the first Kafka request, such as 18 for ApiVersions
+	APIKey int
+
+	// User given in the NATS CONNECT
+	User string
+}
+
+// Parse reads the start of a broker client connection at the start of b.
+// ErrIncomplete is returned while more bytes are needed.
+func Parse(b []byte) (*Hello, error) {
+	h, _, err := parse(b)
+	return h, err
+}
+
+func parse(b []byte) (*Hello, int, error) {
+	need := 0
+	for _, p := range []func([]byte) (*Hello, int, error){parseAMQP, parseKafka, parseNATS} {
+		h, n, err := p(b)
+		switch err {
+		case nil:
+			return h, 0, nil
+		case ErrIncomplete:
+			if need == 0 || n < need {
+				need = n
+			}
+		}
+	}
+	if need > 0 {
+		return nil, need, ErrIncomplete
+	}
+	return nil, 0, errMalformed
+}
+
+// Matching returns a probe matching the clients for which the function
+// returns true.
+func Matching(match func(h *Hello) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		h, need, err := parse(b)
+		return err == nil && match(h), need, err
+	})
+}
+
+// Probe matches any AMQP, Kafka or NATS client.
+var Probe = Matching(func(*Hello) bool { return true })
+
+// Probes matching the clients of a single protocol.
+var (
+	AMQPProbe  = Matching(func(h *Hello) bool { return h.Protocol == AMQP })
+	KafkaProbe = Matching(func(h *Hello) bool { return h.Protocol == Kafka })
+	NATSProbe  = Matching(func(h *Hello) bool { return h.Protocol == NATS })
+)
+
+// ClientID returns a probe matching the clients of the protocol whose
+// identifier starts with any of the given prefixes.
+func ClientID(p Protocol, prefixes ...string) tease.Probe {
+	return Matching(func(h *Hello) bool {
+		if h.Protocol != p {
+			return false
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(h.ClientID, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Read waits for the start of a broker client connection on the teaser and
+// parses it.  No input is consumed.
+func Read(s *tease.Server) (*Hello, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
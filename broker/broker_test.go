@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Kafka request header, with a nil client id when id is "-".
+func kafka(key, version int, id string) []byte {
+	b := []byte{0, 0, 0, 0, byte(key >> 8), byte(key), byte(version >> 8), byte(version), 0, 0, 0, 1}
+	if id == "-" {
+		b = append(b, 0xff, 0xff)
+	} else {
+		b = append(b, byte(len(id)>>8), byte(len(id)))
+		b = append(b, id...)
+	}
+	b = append(b, 0) // tagged fields
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+// AMQP 1.0 header followed by an open frame carrying the container-id.
+func amqpOpen(id string) []byte {
+	open := []byte{0x00, 0x53, 0x10, 0xc0, byte(3 + len(id)), 0x01, 0xa1, byte(len(id))}
+	open = append(open, id...)
+	b := []byte("AMQP\x00\x01\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00")
+	binary.BigEndian.PutUint32(b[8:], uint32(8+len(open)))
+	return append(b, open...)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want Hello
+	}{
+		{"amqp 0-9-1", []byte("AMQP\x00\x00\x09\x01"), Hello{Protocol: AMQP, Version: "0-9-1"}},
+		{"amqp 1.0 sasl", []byte("AMQP\x03\x01\x00\x00"), Hello{Protocol: AMQP, Version: "1.0", Layer: "sasl"}},
+		{"amqp 1.0 open", amqpOpen("orders-7"), Hello{Protocol: AMQP, Version: "1.0", Layer: "amqp", ClientID: "orders-7"}},
+		{"amqp 1.0 partial open", amqpOpen("orders-7")[:12], Hello{Protocol: AMQP, Version: "1.0", Layer: "amqp"}},
+		{"kafka", kafka(18, 3, "producer-1"), Hello{Protocol: Kafka, Version: "ApiVersions v3", ClientID: "producer-1", APIKey: 18}},
+		{"kafka null client id", kafka(3, 1, "-"), Hello{Protocol: Kafka, Version: "Metadata v1", APIKey: 3}},
+		{"nats", []byte(`CONNECT {"name":"svc","user":"bob","protocol":1}` + "\r\nPING\r\n"),
+			Hello{Protocol: NATS, Version: "1", ClientID: "svc", User: "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*h, tt.want) {
+				t.Errorf("got %+v, want %+v", *h, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		need int
+	}{
+		{"empty", nil, 1},
+		{"partial amqp", []byte("AMQ"), 4},
+		{"partial kafka", kafka(18, 3, "p")[:6], 7},
+		{"partial kafka client id", kafka(18, 3, "producer-1")[:16], 24},
+		{"partial nats", []byte("CONN"), 5},
+		{"socks greeting", []byte("\x05\x01\x00"), 0},
+		{"amqp 0-10", []byte("AMQP\x01\x01\x00\x0a"), 0},
+		{"kafka produce", kafka(0, 9, "p"), 0},
+		{"kafka version too high", kafka(18, 9, "p"), 0},
+		{"kafka control in client id", kafka(18, 3, "a\nb"), 0},
+		{"nats without json", []byte("CONNECT hello\r\n"), 0},
+		{"nats line too long", []byte("CONNECT " + strings.Repeat(" ", maxNATSLine)), 0},
+	}
+	for _, tt := range tests {
+		h, need, err := parse(tt.in)
+		if err == nil || need != tt.need || tt.need > 0 && err != ErrIncomplete {
+			t.Errorf("%s: got %+v, %d, %v, want need %d", tt.name, h, need, err, tt.need)
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	amqp := []byte("AMQP\x00\x00\x09\x01")
+	k := kafka(18, 3, "producer-1")
+	tests := []struct {
+		name  string
+		probe tease.Probe
+		in    []byte
+		want  tease.Result
+	}{
+		{"amqp", AMQPProbe, amqp, tease.Match},
+		{"amqp on kafka", AMQPProbe, k, tease.NoMatch},
+		{"kafka", KafkaProbe, k, tease.Match},
+		{"kafka client id", ClientID(Kafka, "consumer-", "producer-"), k, tease.Match},
+		{"kafka other client id", ClientID(Kafka, "consumer-"), k, tease.NoMatch},
+		{"client id of another protocol", ClientID(NATS, "producer-"), k, tease.NoMatch},
+		{"nats", NATSProbe, []byte("CONNECT {}\r\n"), tease.Match},
+		{"any", Probe, amqp, tease.Match},
+	}
+	for _, tt := range tests {
+		if r, _ := tt.probe.Probe(tt.in); r != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, r, tt.want)
+		}
+	}
+}
+
+func TestGreetNATS(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	a.SetDeadline(time.Now().Add(2 * time.Second))
+	b.SetDeadline(time.Now().Add(2 * time.Second))
+
+	errc := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(b).ReadString('\n')
+		if err == nil {
+			var info natsInfo
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err == nil &&
+				(info.ServerName != "edge" || !info.AuthRequired) {
+				t.Errorf("INFO %+v", info)
+			}
+		}
+		if err == nil {
+			_, err = b.Write([]byte(`CONNECT {"name":"svc","user":"bob"}` + "\r\n"))
+		}
+		errc <- err
+	}()
+
+	s := tease.NewServer(a)
+	h, err := GreetNATS(s, "edge", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if h.Protocol != NATS || h.ClientID != "svc" || h.User != "bob" {
+		t.Errorf("got %+v", h)
+	}
+	if in, out := s.Committed(); len(in) != 0 || !strings.HasPrefix(string(out), "INFO {") {
+		t.Errorf("Committed() = %q, %q", in, out)
+	}
+	if !strings.HasPrefix(string(s.Buffered()), "CONNECT ") {
+		t.Errorf("CONNECT not left to replay: %q", s.Buffered())
+	}
+}
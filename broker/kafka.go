@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Kafka requests a client may open with, along with the highest version
+// accepted for each.
+var kafkaAPIs = map[int]struct {
+	name string
+	max  int
+}{
+	3:  {"Metadata", 13},
+	17: {"SaslHandshake", 1},
+	18: {"ApiVersions", 4},
+}
+
+const (
+	// Size, API key and version, correlation id and client id length
+	kafkaHeaderLen = 4 + 2 + 2 + 4 + 2
+
+	// Largest first request accepted, well above what clients send
+	maxKafkaRequest = 1 << 20
+)
+
+// Parse the header of a Kafka request, which up to the client id is the same
+// for all the header versions.  The rest of the request is not waited for.
+func parseKafka(b []byte) (*Hello, int, error) {
+	// Check each field as soon as it is buffered.  Requests are well under
+	// 16 MiB, so the size starts with a zero.
+	if len(b) > 0 && b[0] != 0 {
+		return nil, 0, errMalformed
+	}
+	if len(b) >= 4 {
+		size := int(binary.BigEndian.Uint32(b))
+		if size < kafkaHeaderLen-4 || size > maxKafkaRequest {
+			return nil, 0, errMalformed
+		}
+	}
+	if len(b) >= 8 {
+		api, ok := kafkaAPIs[int(binary.BigEndian.Uint16(b[4:]))]
+		if !ok || int(binary.BigEndian.Uint16(b[6:])) > api.max {
+			return nil, 0, errMalformed
+		}
+	}
+	if len(b) < kafkaHeaderLen {
+		return nil, len(b) + 1, ErrIncomplete
+	}
+
+	size := int(binary.BigEndian.Uint32(b))
+	key := int(binary.BigEndian.Uint16(b[4:]))
+	version := int(binary.BigEndian.Uint16(b[6:]))
+	h := &Hello{
+		Protocol: Kafka,
+		Version:  kafkaAPIs[key].name + " v" + strconv.Itoa(version),
+		APIKey:   key,
+	}
+
+	// Nullable string, -1 for null
+	n := int(int16(binary.BigEndian.Uint16(b[12:])))
+	if n < -1 || kafkaHeaderLen-4+n > size {
+		return nil, 0, errMalformed
+	}
+	if n > 0 {
+		if len(b) < kafkaHeaderLen+n {
+			return nil, kafkaHeaderLen + n, ErrIncomplete
+		}
+		id := b[kafkaHeaderLen : kafkaHeaderLen+n]
+		for _, c := range id {
+			if c < ' ' || c == 0x7f {
+				return nil, 0, errMalformed
+			}
+		}
+		h.ClientID = string(id)
+	}
+	return h, 0, nil
+}
@@ -0,0 +1,174 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Longest NATS protocol line, as accepted by the server by default
+const maxNATSLine = 4096
+
+// Fields of the NATS CONNECT used for routing.
+type natsConnect struct {
+	Name     string `json:"name"`
+	User     string `json:"user"`
+	Protocol int    `json:"protocol"`
+}
+
+// Parse a NATS "CONNECT {json}" line, sent by the client after the INFO of
+// the server.
+func parseNATS(b []byte) (*Hello, int, error) {
+	const verb = "CONNECT "
+	n := len(b)
+	if n > len(verb) {
+		n = len(verb)
+	}
+	if !strings.EqualFold(string(b[:n]), verb[:n]) {
+		return nil, 0, errMalformed
+	}
+	end := bytes.IndexByte(b, '\n')
+	if end < 0 {
+		if len(b) >= maxNATSLine {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+	if end+1 > maxNATSLine {
+		return nil, 0, errMalformed
+	}
+
+	var c natsConnect
+	if err := json.Unmarshal(bytes.TrimSpace(b[len(verb):end]), &c); err != nil {
+		return nil, 0, errMalformed
+	}
+	return &Hello{
+		Protocol: NATS,
+		Version:  strconv.Itoa(c.Protocol),
+		ClientID: c.Name,
+		User:     c.User,
+	}, 0, nil
+}
+
+// NATS INFO sent to the clients.
+type natsInfo struct {
+	ServerID     string `json:"server_id"`
+	ServerName   string `json:"server_name"`
+	Version      string `json:"version"`
+	Proto        int    `json:"proto"`
+	Headers      bool   `json:"headers"`
+	MaxPayload   int    `json:"max_payload"`
+	AuthRequired bool   `json:"auth_required,omitempty"`
+}
+
+// GreetNATS sends an INFO line on the teaser with a write through, so that
+// the client answers with its CONNECT, then reads it.  The CONNECT is left
+// uncommitted, so a Replay followed by Pipe sends it on to the backend.
+//
+// The INFO carries no nonce, so clients authenticating with NKeys or JWTs
+// are not supported.
+func GreetNATS(s *tease.Server, serverName string, authRequired bool) (*Hello, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	info, err := json.Marshal(&natsInfo{
+		ServerID:     strings.ToUpper(hex.EncodeToString(id)),
+		ServerName:   serverName,
+		Version:      "2.10.0",
+		Proto:        1,
+		Headers:      true,
+		MaxPayload:   1 << 20,
+		AuthRequired: authRequired,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Replay()
+	if _, err := s.WriteThrough([]byte("INFO " + string(info) + "\r\n")); err != nil {
+		return nil, err
+	}
+	if _, err := s.Detect(NATSProbe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
+
+// NATSRouter is a tease.Handler for the Mux silence route, which greets NATS
+// clients and forwards them to the cluster picked by Route.  The INFO line of
+// the backend is read and dropped, as the client already has one, and the
+// CONNECT is replayed to it before the connection is spliced.
+type NATSRouter struct {
+	// Name announced in the INFO line
+	ServerName string
+
+	// Ask clients for their credentials, which are checked by the backend
+	AuthRequired bool
+
+	// Pick the backend address for a client, or return an empty address to
+	// drop it.
+	Route func(h *Hello) string
+
+	// Time allowed to reach the cluster picked by Route.  NATS clients treat
+	// a slow answer to their CONNECT as a failed attempt and move to the next
+	// server of their list.  Zero leaves it to the operating system.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the backends.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// PROXY protocol version of the header sent to the backends ahead of the
+	// replayed CONNECT, zero for none.
+	ProxyProtocol int
+}
+
+// ServeTease greets the client, picks the backend and splices the connection
+// to it.
+func (r *NATSRouter) ServeTease(s *tease.Server) {
+	h, err := GreetNATS(s, r.ServerName, r.AuthRequired)
+	if err != nil {
+		s.Abort()
+		return
+	}
+	addr := r.Route(h)
+	if addr == "" {
+		s.Abort()
+		return
+	}
+
+	f := &tease.Forwarder{
+		Addr:          addr,
+		DialTimeout:   r.DialTimeout,
+		Dial:          r.Dial,
+		ProxyProtocol: r.ProxyProtocol,
+		Protocol:      "nats",
+		Prepare:       skipInfo,
+	}
+	f.ServeTease(s)
+}
+
+// Read the INFO line of a backend one byte at a time, so nothing past it is
+// lost.
+func skipInfo(conn net.Conn) error {
+	var line []byte
+	c := []byte{0}
+	for len(line) < 64*1024 {
+		if _, err := conn.Read(c); err != nil {
+			return err
+		}
+		line = append(line, c[0])
+		if c[0] == '\n' {
+			if !bytes.HasPrefix(bytes.ToUpper(line), []byte("INFO")) {
+				return errMalformed
+			}
+			return nil
+		}
+	}
+	return errMalformed
+}
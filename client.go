@@ -19,11 +19,16 @@ type Client struct {
 	// read past this the connection is terminated.
 	MaxBuffer int
 
+	// PROXY protocol header sent at the start of every backend connection,
+	// ahead of any buffered output.  Nil sends no header.
+	ProxyHeader *ProxyHeader
+
 	// input/output
 	rawInput  []byte // raw input buffer
 	inputCnt  int
 	rawOutput []byte // raw output buffer
 	outputCnt int
+	proxySent bool
 	mu        sync.Mutex
 }
 
@@ -43,10 +48,28 @@ func (c *Client) SetNewConn(conn net.Conn) (err error) {
 		c.conn.Close()
 	}
 	c.conn = conn
+	c.proxySent = false
+	if err = c.writeProxyHeader(); err != nil {
+		return
+	}
 	c.outputCnt, err = c.conn.Write(c.rawOutput)
 	return
 }
 
+// Send the PROXY protocol header once per backend connection.
+func (c *Client) writeProxyHeader() error {
+	if c.ProxyHeader == nil || c.proxySent {
+		return nil
+	}
+	hdr, err := c.ProxyHeader.Format()
+	if err != nil {
+		return err
+	}
+	c.proxySent = true
+	_, err = c.conn.Write(hdr)
+	return err
+}
+
 func (c *Client) String() string {
 	return fmt.Sprintf("tease_client{pipe: %v, read: %d, readQue: %d, write: %d, writeQue: %d}",
 		c.isPiped, c.inputCnt, len(c.rawInput), c.outputCnt, len(c.rawOutput))
@@ -123,6 +146,9 @@ func (c *Client) Read(b []byte) (n int, err error) {
 func (c *Client) Write(b []byte) (n int, err error) {
 	// Short circuit if in pipe mode
 	if c.isPiped {
+		if err = c.writeProxyHeader(); err != nil {
+			return
+		}
 		n, err = c.conn.Write(b)
 		return
 	}
@@ -150,6 +176,9 @@ func (c *Client) write(b []byte) (n int, err error) {
 	c.rawOutput = append(c.rawOutput, b...)
 	n = len(b)
 
+	if err = c.writeProxyHeader(); err != nil {
+		return
+	}
 	n, err = c.conn.Write(b)
 	c.outputCnt += n
 	return
@@ -157,6 +186,13 @@ func (c *Client) write(b []byte) (n int, err error) {
 
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
+//
+// Before Pipe() is called, Close only marks the teaser as closed.  Protocol
+// testers such as crypto/tls or net/http close the connection when the input
+// is not theirs, and the connection must survive that for Replay() to hand it
+// to the next tester.  A teaser is therefore only given to code which may
+// close it for good once piped, as the Mux and Handlers of this module do, and
+// a connection still in tease mode is dropped with Abort.
 func (c *Client) Close() error {
 	if c.isPiped {
 		// Only allow piped connections to be closed
@@ -167,6 +203,35 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// Abort closes the connection whether or not it has been piped, discarding
+// any buffered output.  When teasers are stacked, for instance a teaser
+// wrapping a crypto/tls connection over another teaser, the connection at the
+// bottom of the stack is closed, so the outer layers are not leaked while the
+// inner one is still in tease mode.
+func (c *Client) Abort() error {
+	c.mu.Lock()
+	c.err = errClosed
+	c.rawOutput = nil
+	c.mu.Unlock()
+
+	base := BaseConn(c.conn)
+	if base != c.conn {
+		c.conn.Close()
+	}
+	return base.Close()
+}
+
+// Unwrap returns the connection wrapped by the teaser.
+func (c *Client) Unwrap() net.Conn {
+	return c.conn
+}
+
+// NetConn returns the connection wrapped by the teaser, named after the
+// crypto/tls accessor so that both can be walked the same way.
+func (c *Client) NetConn() net.Conn {
+	return c.conn
+}
+
 // LocalAddr returns the local network address.
 func (c *Client) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
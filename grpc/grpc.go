@@ -0,0 +1,83 @@
+/*
+Package grpc detects gRPC calls on a cleartext HTTP/2 connection by decoding
+the first header block out of the teaser buffer, so that services can be
+routed to different backends than other HTTP/2 traffic on the same port.
+*/
+package grpc
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/h2"
+)
+
+var errNotGRPC = errors.New("grpc: not a gRPC request")
+
+// Call is the first gRPC call made on a connection.
+type Call struct {
+	*h2.Request
+
+	// Fully qualified service name and method, from the :path of the form
+	// "/pkg.Service/Method".  The RPC method is named apart from Method, which
+	// is still the HTTP :method of the embedded request.
+	Service string
+	RPC     string
+}
+
+// Parse decodes the first request of the HTTP/2 connection at the start of b
+// and checks it is a gRPC call.  h2.ErrIncomplete is returned while more bytes
+// are needed.
+func Parse(b []byte) (*Call, error) {
+	req, err := h2.ParseRequest(b)
+	if err != nil {
+		return nil, err
+	}
+	return newCall(req)
+}
+
+func newCall(req *h2.Request) (*Call, error) {
+	if !isGRPC(req) {
+		return nil, errNotGRPC
+	}
+	c := &Call{Request: req}
+	if parts := strings.SplitN(req.Path, "/", 3); len(parts) == 3 && parts[0] == "" {
+		c.Service, c.RPC = parts[1], parts[2]
+	}
+	return c, nil
+}
+
+// The content type is "application/grpc" with an optional "+proto" like
+// suffix or parameters.
+func isGRPC(req *h2.Request) bool {
+	ct := strings.ToLower(req.ContentType)
+	return req.Method == "POST" && (ct == "application/grpc" ||
+		strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;"))
+}
+
+// Probe matches connections whose first request is a gRPC call.
+var Probe = h2.RequestMatching(isGRPC)
+
+// Route returns a probe matching the gRPC calls whose :path matches the glob
+// pattern of path.Match, such as "/pkg.Service/*".
+func Route(pattern string) tease.Probe {
+	return h2.RequestMatching(func(req *h2.Request) bool {
+		if !isGRPC(req) {
+			return false
+		}
+		ok, _ := path.Match(pattern, req.Path)
+		return ok
+	})
+}
+
+// Read waits for the first request on the teaser and returns it when it is a
+// gRPC call.  No input is consumed.
+func Read(s *tease.Server) (*Call, error) {
+	req, err := h2.ReadRequest(s)
+	if err != nil {
+		return nil, err
+	}
+	return newCall(req)
+}
@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"testing"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/h2"
+)
+
+// Start of a connection with a request carrying the header fields, given as
+// name and value pairs sent as literals without indexing.
+func request(fields ...string) []byte {
+	var block []byte
+	for i := 0; i+1 < len(fields); i += 2 {
+		block = append(block, 0x00, byte(len(fields[i])))
+		block = append(block, fields[i]...)
+		block = append(block, byte(len(fields[i+1])))
+		block = append(block, fields[i+1]...)
+	}
+	b := []byte(h2.ClientPreface)
+	// Empty SETTINGS, then HEADERS with END_HEADERS on stream 1
+	b = append(b, 0, 0, 0, 0x04, 0, 0, 0, 0, 0)
+	b = append(b, 0, byte(len(block)>>8), byte(len(block)), 0x01, 0x04, 0, 0, 0, 1)
+	return append(b, block...)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		service string
+		rpc     string
+		err     error
+	}{
+		{
+			name: "call",
+			in: request(":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello",
+				"content-type", "application/grpc"),
+			service: "helloworld.Greeter",
+			rpc:     "SayHello",
+		},
+		{
+			name: "proto suffix",
+			in: request(":method", "POST", ":path", "/pkg.Service/Do",
+				"content-type", "Application/gRPC+proto"),
+			service: "pkg.Service",
+			rpc:     "Do",
+		},
+		{
+			name: "get",
+			in:   request(":method", "GET", ":path", "/pkg.Service/Do", "content-type", "application/grpc"),
+			err:  errNotGRPC,
+		},
+		{
+			name: "other content type",
+			in:   request(":method", "POST", ":path", "/upload", "content-type", "application/grpc-web"),
+			err:  errNotGRPC,
+		},
+		{
+			name: "incomplete",
+			in:   []byte(h2.ClientPreface),
+			err:  h2.ErrIncomplete,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(tt.in)
+			if err != tt.err {
+				t.Fatalf("Parse() error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if c.Service != tt.service || c.RPC != tt.rpc || c.Method != "POST" {
+				t.Errorf("service %q, RPC %q, method %q", c.Service, c.RPC, c.Method)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	b := request(":method", "POST", ":path", "/pkg.Service/Do", "content-type", "application/grpc")
+	tests := []struct {
+		pattern string
+		result  tease.Result
+	}{
+		{"/pkg.Service/*", tease.Match},
+		{"/pkg.Service/Do", tease.Match},
+		{"/pkg.*/*", tease.Match},
+		{"/pkg.Other/*", tease.NoMatch},
+		{"/pkg.Service", tease.NoMatch},
+	}
+	for _, tt := range tests {
+		if r, _ := Route(tt.pattern).Probe(b); r != tt.result {
+			t.Errorf("Route(%q) = %v, want %v", tt.pattern, r, tt.result)
+		}
+	}
+	if r, _ := Probe.Probe(b); r != tease.Match {
+		t.Errorf("Probe = %v", r)
+	}
+}
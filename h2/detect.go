@@ -0,0 +1,196 @@
+package h2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/http1"
+)
+
+// ClientPreface starts every HTTP/2 connection made with prior knowledge.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Identifiers of the SETTINGS parameters
+const (
+	SettingHeaderTableSize      uint16 = 0x1
+	SettingEnablePush           uint16 = 0x2
+	SettingMaxConcurrentStreams uint16 = 0x3
+	SettingInitialWindowSize    uint16 = 0x4
+	SettingMaxFrameSize         uint16 = 0x5
+	SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+// Setting is a parameter of a SETTINGS frame.
+type Setting struct {
+	ID  uint16
+	Val uint32
+}
+
+// Mode is the way a client starts talking on a cleartext HTTP port.
+type Mode int
+
+const (
+	// Plain HTTP/1.x without an upgrade
+	HTTP1 Mode = iota
+
+	// HTTP/2 with prior knowledge, starting with the client preface
+	PriorKnowledge
+
+	// HTTP/1.1 request asking to upgrade to h2c
+	Upgrade
+)
+
+func (m Mode) String() string {
+	switch m {
+	case HTTP1:
+		return "http/1.1"
+	case PriorKnowledge:
+		return "h2c prior knowledge"
+	case Upgrade:
+		return "h2c upgrade"
+	}
+	return "unknown"
+}
+
+// Detection is the outcome of reading the start of a cleartext connection.
+type Detection struct {
+	Mode Mode
+
+	// Client settings, from the first SETTINGS frame with prior knowledge or
+	// the HTTP2-Settings header of an upgrade
+	Settings []Setting
+
+	// Request head for HTTP1 and Upgrade
+	Request *http1.Request
+
+	// Length of the preface and the SETTINGS frame with prior knowledge
+	PrefaceLen int
+}
+
+// ParsePreface reads the client preface and the SETTINGS frame which must
+// follow it, returning their total length.  When b is incomplete,
+// ErrIncomplete is returned and n is the length worth waiting for before
+// trying again, which is one more byte while the preface itself is partial.
+func ParsePreface(b []byte) ([]Setting, int, error) {
+	if len(b) < len(ClientPreface) {
+		if !bytes.HasPrefix([]byte(ClientPreface), b) {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+	if !bytes.HasPrefix(b, []byte(ClientPreface)) {
+		return nil, 0, errMalformed
+	}
+
+	off := len(ClientPreface)
+	f, n, err := readFrame(b[off:])
+	if err == ErrIncomplete {
+		return nil, off + n, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if f.typ != FrameSettings || f.flags&FlagAck != 0 || f.stream != 0 {
+		return nil, 0, errMalformed
+	}
+	settings, err := parseSettings(f.payload)
+	return settings, off + n, err
+}
+
+func parseSettings(b []byte) ([]Setting, error) {
+	if len(b)%6 != 0 {
+		return nil, errMalformed
+	}
+	settings := make([]Setting, 0, len(b)/6)
+	for ; len(b) > 0; b = b[6:] {
+		settings = append(settings, Setting{
+			ID:  uint16(b[0])<<8 | uint16(b[1]),
+			Val: uint32(b[2])<<24 | uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5]),
+		})
+	}
+	return settings, nil
+}
+
+// Check a request for "Upgrade: h2c" along with its HTTP2-Settings.
+func upgradeSettings(req *http1.Request) ([]Setting, bool) {
+	if req.ProtoMinor != 1 || !req.HasToken("Upgrade", "h2c") ||
+		!req.HasToken("Connection", "upgrade") {
+		return nil, false
+	}
+	values := req.Header.Values("Http2-Settings")
+	if len(values) != 1 {
+		return nil, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(values[0], "="))
+	if err != nil {
+		return nil, false
+	}
+	settings, err := parseSettings(raw)
+	if err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+// Detect works out the mode of a cleartext HTTP connection from the start of
+// b.  ErrIncomplete is returned while more bytes are needed.
+func Detect(b []byte) (*Detection, error) {
+	d, _, err := detect(b)
+	return d, err
+}
+
+func detect(b []byte) (*Detection, int, error) {
+	if len(b) > 0 && b[0] == 'P' {
+		settings, n, err := ParsePreface(b)
+		switch err {
+		case nil:
+			return &Detection{Mode: PriorKnowledge, Settings: settings, PrefaceLen: n}, 0, nil
+		case ErrIncomplete:
+			return nil, n, err
+		}
+		// "POST" and friends fall through to HTTP/1
+	}
+
+	req, err := http1.Parse(b)
+	switch err {
+	case nil:
+	case http1.ErrIncomplete:
+		return nil, len(b) + 1, ErrIncomplete
+	default:
+		return nil, 0, err
+	}
+	if settings, ok := upgradeSettings(req); ok {
+		return &Detection{Mode: Upgrade, Settings: settings, Request: req}, 0, nil
+	}
+	return &Detection{Mode: HTTP1, Request: req}, 0, nil
+}
+
+// Probe returns a probe matching the connections detected in the given mode.
+func Probe(mode Mode) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		d, need, err := detect(b)
+		return err == nil && d.Mode == mode, need, err
+	})
+}
+
+// Probes matching each mode, for use with tease.Mux.
+var (
+	PriorKnowledgeProbe = Probe(PriorKnowledge)
+	UpgradeProbe        = Probe(Upgrade)
+	HTTP1Probe          = Probe(HTTP1)
+)
+
+// Read waits until the mode of the connection on the teaser is known.  No
+// input is consumed.
+func Read(s *tease.Server) (*Detection, error) {
+	probe := tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		_, need, err := detect(b)
+		return err == nil, need, err
+	})
+	if _, err := s.Detect(probe); err != nil {
+		return nil, err
+	}
+	return Detect(s.Buffered())
+}
@@ -0,0 +1,10 @@
+/*
+Package h2 detects cleartext HTTP/2 (h2c) on a teaser, either started with
+prior knowledge by the connection preface or by an HTTP/1.1 "Upgrade: h2c"
+request, and reports the client SETTINGS.
+
+Nothing is consumed from the connection.  A prior knowledge connection can be
+handed to an HTTP/2 server, such as net/http with unencrypted HTTP/2 enabled,
+and the other HTTP/1.1 connections to net/http as usual.
+*/
+package h2
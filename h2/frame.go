@@ -0,0 +1,67 @@
+package h2
+
+import "errors"
+
+// Frame types of RFC 9113 section 6
+const (
+	FrameData         = 0x0
+	FrameHeaders      = 0x1
+	FramePriority     = 0x2
+	FrameRSTStream    = 0x3
+	FrameSettings     = 0x4
+	FramePushPromise  = 0x5
+	FramePing         = 0x6
+	FrameGoAway       = 0x7
+	FrameWindowUpdate = 0x8
+	FrameContinuation = 0x9
+)
+
+// Frame flags
+const (
+	FlagAck        = 0x1
+	FlagEndStream  = 0x1
+	FlagEndHeaders = 0x4
+	FlagPadded     = 0x8
+	FlagPriority   = 0x20
+)
+
+const (
+	frameHeaderLen = 9
+
+	// Largest frame a client may send before the SETTINGS are acknowledged
+	maxInitialFrameLen = 16384
+)
+
+var (
+	// Returned when the buffer ends before what is being parsed
+	ErrIncomplete = errors.New("h2: incomplete frame")
+	errMalformed  = errors.New("h2: malformed frame")
+)
+
+// A frame sliced out of the buffer.
+type frame struct {
+	typ     uint8
+	flags   uint8
+	stream  uint32
+	payload []byte
+}
+
+// Slice the frame at the start of b, returning its total length.  When
+// incomplete, n is the number of bytes needed.
+func readFrame(b []byte) (f frame, n int, err error) {
+	if len(b) < frameHeaderLen {
+		return f, frameHeaderLen, ErrIncomplete
+	}
+	l := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	if l > maxInitialFrameLen {
+		return f, 0, errMalformed
+	}
+	n = frameHeaderLen + l
+	if len(b) < n {
+		return f, n, ErrIncomplete
+	}
+	f.typ, f.flags = b[3], b[4]
+	f.stream = (uint32(b[5])<<24 | uint32(b[6])<<16 | uint32(b[7])<<8 | uint32(b[8])) & 0x7fffffff
+	f.payload = b[frameHeaderLen:n]
+	return f, n, nil
+}
@@ -0,0 +1,161 @@
+package h2
+
+import (
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Request holds the first request of an HTTP/2 connection made with prior
+// knowledge, decoded from its header block.
+type Request struct {
+	// Client settings from the SETTINGS frame following the preface
+	Settings []Setting
+
+	// Stream carrying the request
+	StreamID uint32
+
+	// Decoded header fields, pseudo headers included, in order
+	Fields []HeaderField
+
+	// Values of the pseudo headers and of the content-type header
+	Method      string
+	Scheme      string
+	Authority   string
+	Path        string
+	ContentType string
+}
+
+// Get returns the first value of the named field, names being lower case in
+// HTTP/2.
+func (r *Request) Get(name string) string {
+	name = strings.ToLower(name)
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// ParseRequest reads past the preface, the SETTINGS frame and any other
+// connection level frames up to the first HEADERS frame, along with its
+// CONTINUATION frames, and decodes the header block.  ErrIncomplete is
+// returned while the block is not complete.
+func ParseRequest(b []byte) (*Request, error) {
+	r, _, err := parseRequest(b)
+	return r, err
+}
+
+// As ParseRequest, returning the bytes needed when incomplete.
+func parseRequest(b []byte) (*Request, int, error) {
+	settings, off, err := ParsePreface(b)
+	if err != nil {
+		return nil, off, err
+	}
+	req := &Request{Settings: settings}
+
+	var block []byte
+	for {
+		f, n, err := readFrame(b[off:])
+		if err == ErrIncomplete {
+			return nil, off + n, err
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+
+		if block != nil {
+			// Only CONTINUATION frames may follow an unfinished HEADERS frame
+			if f.typ != FrameContinuation || f.stream != req.StreamID {
+				return nil, 0, errMalformed
+			}
+			block = append(block, f.payload...)
+			if f.flags&FlagEndHeaders != 0 {
+				break
+			}
+			continue
+		}
+
+		switch f.typ {
+		case FrameSettings, FrameWindowUpdate, FramePriority, FramePing:
+			continue
+		case FrameHeaders:
+		default:
+			return nil, 0, errMalformed
+		}
+		if f.stream == 0 || f.stream%2 == 0 {
+			return nil, 0, errMalformed
+		}
+		req.StreamID = f.stream
+		frag, ok := headersFragment(f)
+		if !ok {
+			return nil, 0, errMalformed
+		}
+		block = append([]byte{}, frag...)
+		if f.flags&FlagEndHeaders != 0 {
+			break
+		}
+	}
+
+	if req.Fields, err = newDecoder().decode(block); err != nil {
+		return nil, 0, err
+	}
+	for _, f := range req.Fields {
+		switch f.Name {
+		case ":method":
+			req.Method = f.Value
+		case ":scheme":
+			req.Scheme = f.Value
+		case ":authority":
+			req.Authority = f.Value
+		case ":path":
+			req.Path = f.Value
+		case "content-type":
+			req.ContentType = f.Value
+		}
+	}
+	return req, 0, nil
+}
+
+// Strip the padding and priority fields from a HEADERS frame payload.
+func headersFragment(f frame) ([]byte, bool) {
+	p := f.payload
+	pad := 0
+	if f.flags&FlagPadded != 0 {
+		if len(p) < 1 {
+			return nil, false
+		}
+		pad, p = int(p[0]), p[1:]
+	}
+	if f.flags&FlagPriority != 0 {
+		if len(p) < 5 {
+			return nil, false
+		}
+		p = p[5:]
+	}
+	if pad > len(p) {
+		return nil, false
+	}
+	return p[:len(p)-pad], true
+}
+
+// RequestMatching returns a probe matching the prior knowledge connections
+// whose first request satisfies the function.
+func RequestMatching(match func(r *Request) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		req, need, err := parseRequest(b)
+		return err == nil && match(req), need, err
+	})
+}
+
+// ReadRequest waits for the first header block of a prior knowledge
+// connection on the teaser and decodes it.  No input is consumed.
+func ReadRequest(s *tease.Server) (*Request, error) {
+	probe := RequestMatching(func(*Request) bool { return true })
+	if _, err := s.Detect(probe); err != nil {
+		return nil, err
+	}
+	return ParseRequest(s.Buffered())
+}
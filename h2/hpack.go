@@ -0,0 +1,194 @@
+package h2
+
+import (
+	"errors"
+	"sync"
+)
+
+const defaultHeaderTableSize = 4096
+
+var errHpack = errors.New("h2: malformed header block")
+
+// HeaderField is a name-value pair of a decoded header block.
+type HeaderField struct {
+	Name, Value string
+}
+
+// HPACK decoder of RFC 7541, holding the dynamic table of one connection.
+type decoder struct {
+	dyn     []HeaderField // newest entry last
+	size    int
+	maxSize int
+}
+
+func newDecoder() *decoder {
+	return &decoder{maxSize: defaultHeaderTableSize}
+}
+
+// Decode a complete header block.
+func (d *decoder) decode(b []byte) (fields []HeaderField, err error) {
+	for len(b) > 0 {
+		var f HeaderField
+		switch {
+		case b[0]&0x80 != 0: // Indexed field
+			var i uint64
+			if i, b, err = readInt(b, 7); err != nil {
+				return nil, err
+			}
+			if f, err = d.at(i); err != nil {
+				return nil, err
+			}
+
+		case b[0]&0xc0 == 0x40: // Literal with incremental indexing
+			if f, b, err = d.literal(b, 6); err != nil {
+				return nil, err
+			}
+			d.add(f)
+
+		case b[0]&0xe0 == 0x20: // Dynamic table size update
+			var size uint64
+			if size, b, err = readInt(b, 5); err != nil {
+				return nil, err
+			}
+			if size > defaultHeaderTableSize {
+				return nil, errHpack
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+
+		default: // Literal without indexing or never indexed
+			if f, b, err = d.literal(b, 4); err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Entry at index i of the combined static and dynamic tables.
+func (d *decoder) at(i uint64) (HeaderField, error) {
+	switch {
+	case i == 0:
+	case i <= uint64(len(staticTable)):
+		return staticTable[i-1], nil
+	case i-uint64(len(staticTable)) <= uint64(len(d.dyn)):
+		return d.dyn[len(d.dyn)-int(i-uint64(len(staticTable)))], nil
+	}
+	return HeaderField{}, errHpack
+}
+
+// Literal field whose name index has the given prefix length.
+func (d *decoder) literal(b []byte, prefix uint8) (f HeaderField, rest []byte, err error) {
+	var i uint64
+	if i, b, err = readInt(b, prefix); err != nil {
+		return
+	}
+	if i > 0 {
+		var named HeaderField
+		if named, err = d.at(i); err != nil {
+			return
+		}
+		f.Name = named.Name
+	} else if f.Name, b, err = readString(b); err != nil {
+		return
+	}
+	f.Value, rest, err = readString(b)
+	return
+}
+
+func (d *decoder) add(f HeaderField) {
+	d.dyn = append(d.dyn, f)
+	d.size += len(f.Name) + len(f.Value) + 32
+	d.evict()
+}
+
+func (d *decoder) evict() {
+	for d.size > d.maxSize && len(d.dyn) > 0 {
+		d.size -= len(d.dyn[0].Name) + len(d.dyn[0].Value) + 32
+		d.dyn = d.dyn[1:]
+	}
+}
+
+// Integer with an N bit prefix, section 5.1
+func readInt(b []byte, n uint8) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errHpack
+	}
+	mask := uint64(1)<<n - 1
+	v := uint64(b[0]) & mask
+	b = b[1:]
+	if v < mask {
+		return v, b, nil
+	}
+	for m := uint(0); len(b) > 0; m += 7 {
+		if m > 56 {
+			return 0, nil, errHpack
+		}
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << m
+		if c&0x80 == 0 {
+			return v, b, nil
+		}
+	}
+	return 0, nil, errHpack
+}
+
+// String literal, optionally Huffman coded, section 5.2
+func readString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, errHpack
+	}
+	huffman := b[0]&0x80 != 0
+	l, b, err := readInt(b, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if l > uint64(len(b)) {
+		return "", nil, errHpack
+	}
+	s, b := b[:l], b[l:]
+	if !huffman {
+		return string(s), b, nil
+	}
+	v, err := huffmanDecode(s)
+	return v, b, err
+}
+
+var (
+	huffmanOnce   sync.Once
+	huffmanCodeOf map[uint64]byte // keyed on code length << 32 | code
+)
+
+// Decode a Huffman coded string bit by bit, checking the padding is a prefix
+// of the EOS code as section 5.2 requires.
+func huffmanDecode(b []byte) (string, error) {
+	huffmanOnce.Do(func() {
+		huffmanCodeOf = make(map[uint64]byte, 256)
+		for sym, code := range huffmanCodes {
+			huffmanCodeOf[uint64(huffmanCodeLen[sym])<<32|uint64(code)] = byte(sym)
+		}
+	})
+
+	out := make([]byte, 0, len(b)*8/5)
+	var code uint32
+	var bits uint8
+	for _, c := range b {
+		for i := 7; i >= 0; i-- {
+			code = code<<1 | uint32(c>>uint(i)&1)
+			bits++
+			if sym, ok := huffmanCodeOf[uint64(bits)<<32|uint64(code)]; ok {
+				out = append(out, sym)
+				code, bits = 0, 0
+			} else if bits >= 30 {
+				return "", errHpack
+			}
+		}
+	}
+	if bits > 7 || code != uint32(1)<<bits-1 {
+		return "", errHpack
+	}
+	return string(out), nil
+}
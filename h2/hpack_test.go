@@ -0,0 +1,123 @@
+package h2
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The request examples of RFC 7541 appendix C.3 and C.4, decoded in turn on
+// one connection so the dynamic table carries over.
+func TestDecodeRFCExamples(t *testing.T) {
+	want := [][]HeaderField{
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+			{"cache-control", "no-cache"}},
+		{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"},
+			{"custom-key", "custom-value"}},
+	}
+	sizes := []int{57, 110, 164}
+	tests := []struct {
+		name   string
+		blocks []string
+	}{
+		{"plain", []string{
+			"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			"8286 84be 5808 6e6f 2d63 6163 6865",
+			"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+		}},
+		{"huffman", []string{
+			"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+			"8286 84be 5886 a8eb 1064 9cbf",
+			"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDecoder()
+			for i, block := range tt.blocks {
+				fields, err := d.decode(unhex(t, block))
+				if err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+				if !reflect.DeepEqual(fields, want[i]) {
+					t.Errorf("request %d: got %v, want %v", i+1, fields, want[i])
+				}
+				if d.size != sizes[i] {
+					t.Errorf("request %d: table size %d, want %d", i+1, d.size, sizes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadInt(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    uint8
+		want uint64
+		ok   bool
+	}{
+		{"0a", 5, 10, true},
+		{"1f9a0a", 5, 1337, true},
+		{"2a", 8, 42, true},
+		{"1f", 5, 0, false},
+		{"1f9a", 5, 0, false},
+		{"1fffffffffffffffffff01", 5, 0, false},
+	}
+	for _, tt := range tests {
+		v, rest, err := readInt(unhex(t, tt.in), tt.n)
+		if tt.ok != (err == nil) || v != tt.want || len(rest) != 0 {
+			t.Errorf("readInt(%s, %d) = %d, %x, %v", tt.in, tt.n, v, rest, err)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+	}{
+		{"index zero", "80"},
+		{"index past the tables", "ff00"},
+		{"size update over the limit", "3fe21f"},
+		{"truncated value", "0f2b05616263"},
+		{"huffman padding not ones", "0f2b8100"},
+		{"huffman padding too long", "0f2b82ffff"},
+	}
+	for _, tt := range tests {
+		if fields, err := newDecoder().decode(unhex(t, tt.block)); err == nil {
+			t.Errorf("%s: decoded %v", tt.name, fields)
+		}
+	}
+}
+
+func TestDynamicTableEviction(t *testing.T) {
+	d := newDecoder()
+	// Shrink the table to 58 bytes, room for one 36 byte entry, then add two
+	block := unhex(t, "3f1b 4003 6b65 7901 61 4003 6b65 7901 62 be")
+	fields, err := d.decode(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HeaderField{{"key", "a"}, {"key", "b"}, {"key", "b"}}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v, want %v", fields, want)
+	}
+	if len(d.dyn) != 1 || d.size != 36 {
+		t.Errorf("table %v of size %d", d.dyn, d.size)
+	}
+	if _, err := d.decode(unhex(t, "bf")); err == nil {
+		t.Error("evicted entry still indexed")
+	}
+}
@@ -0,0 +1,164 @@
+package tease
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// A Handler takes over a connection once its protocol has been detected.  The
+// Server is handed over still in tease mode, with the read position at the
+// start of the input, so the handler may inspect the buffered input before
+// calling Pipe().  The handler is responsible for closing the connection, with
+// Abort() if it is dropped before being piped.
+//
+// The read deadline of Mux.ReadTimeout is kept while in tease mode, so a
+// handler negotiating with the client before calling Pipe() is bounded by it.
+// A handler which keeps reading after Pipe(), before handing the connection
+// off, should set a deadline of its own.
+type Handler interface {
+	ServeTease(s *Server)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as
+// handlers.
+type HandlerFunc func(s *Server)
+
+// ServeTease calls f(s).
+func (f HandlerFunc) ServeTease(s *Server) {
+	f(s)
+}
+
+// Forwarder is a Handler which passes the connection through to a backend,
+// replaying the buffered input before splicing both directions.
+type Forwarder struct {
+	// Address of the backend
+	Addr string
+
+	// Network of the backend, "tcp" if empty
+	Network string
+
+	// Time allowed to connect to Addr before the client is dropped.  Zero
+	// leaves it to the operating system, and Dial brings its own.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the backend.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// PROXY protocol version of the header sent to the backend ahead of the
+	// replayed input, carrying the original client address.  Zero sends no
+	// header.
+	ProxyProtocol int
+
+	// Name of the detected protocol, sent in a PP2TypeProtocol TLV of a v2
+	// header when set.
+	Protocol string
+
+	// Additional TLVs sent in a v2 header, such as PP2TypeAuthority for the
+	// server name or PP2TypeALPN.
+	TLVs []ProxyTLV
+
+	// Optional step run on the backend after the PROXY header and before the
+	// replayed input, such as taking the backend through the negotiation the
+	// client already had with the teaser.  The connection is dropped when it
+	// returns an error.
+	Prepare func(backend net.Conn) error
+}
+
+// Create a new forwarder to the given tcp address.
+func Forward(addr string) *Forwarder {
+	return &Forwarder{Addr: addr}
+}
+
+// ServeTease dials the backend and splices the connection to it.  The
+// connection is dropped if the backend cannot be reached.
+func (f *Forwarder) ServeTease(s *Server) {
+	backend, err := f.dial()
+	if err != nil {
+		s.Abort()
+		return
+	}
+	if f.ProxyProtocol > 0 {
+		if err := f.writeProxyHeader(backend, s); err != nil {
+			backend.Close()
+			s.Abort()
+			return
+		}
+	}
+	if f.Prepare != nil {
+		if err := f.Prepare(backend); err != nil {
+			backend.Close()
+			s.Abort()
+			return
+		}
+	}
+	s.Replay()
+	if err := s.Pipe(); err != nil {
+		backend.Close()
+		s.Abort()
+		return
+	}
+	Splice(s, backend)
+}
+
+func (f *Forwarder) writeProxyHeader(backend net.Conn, s *Server) error {
+	h := NewProxyHeader(f.ProxyProtocol, s)
+	h.TLVs = append(h.TLVs, f.TLVs...)
+	if f.Protocol != "" {
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: PP2TypeProtocol, Value: []byte(f.Protocol)})
+	}
+	for _, t := range f.TLVs {
+		switch t.Type {
+		case PP2TypeALPN:
+			h.ALPN = t.Value
+		case PP2TypeAuthority:
+			h.Authority = string(t.Value)
+		case PP2TypeUniqueID:
+			h.UniqueID = t.Value
+		}
+	}
+	hdr, err := h.Format()
+	if err != nil {
+		return err
+	}
+	_, err = backend.Write(hdr)
+	return err
+}
+
+func (f *Forwarder) dial() (net.Conn, error) {
+	network := f.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if f.Dial != nil {
+		return f.Dial(network, f.Addr)
+	}
+	return net.DialTimeout(network, f.Addr, f.DialTimeout)
+}
+
+// Splice copies data in both directions between a and b until both sides are
+// done, then closes both connections.  When one side finishes sending, the
+// write side of the other connection is shut down if it supports it.  The
+// first error encountered is returned.
+func Splice(a, b net.Conn) error {
+	errc := make(chan error, 2)
+	cp := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		errc <- err
+	}
+	go cp(a, b)
+	go cp(b, a)
+
+	err := <-errc
+	if err2 := <-errc; err == nil {
+		err = err2
+	}
+	a.Close()
+	b.Close()
+	return err
+}
@@ -0,0 +1,128 @@
+package http1
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// ConnectProbe matches connections starting with an HTTP CONNECT request.
+var ConnectProbe = Matching(func(r *Request) bool { return r.Method == "CONNECT" })
+
+// ConnectProxy is a tease.Handler acting as an HTTP forward proxy for CONNECT
+// requests.  The target is dialed and, once reachable, the client is answered
+// with "200 Connection established" and both directions are spliced.  Any
+// bytes the client sent after the request head are passed on to the target.
+type ConnectProxy struct {
+	// Optional check of the Proxy-Authorization basic credentials.  When set,
+	// requests without valid credentials are answered with a 407.
+	Authorize func(user, password string) bool
+
+	// Realm announced in the Proxy-Authenticate challenge
+	Realm string
+
+	// Check of the target, given as the host and port the client asked for,
+	// before it is dialed.  Refused targets are answered with a 403.  All
+	// targets are refused when nil, so that the proxy is never open by
+	// default.
+	Allow func(host string, port int) bool
+
+	// Time allowed to connect to the requested target before the client is
+	// answered with 502 Bad Gateway.  Zero waits as long as the system does;
+	// not used with Dial.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the targets.
+	Dial func(network, addr string) (net.Conn, error)
+}
+
+// ServeTease answers the CONNECT request and splices the connection to its
+// target.
+func (p *ConnectProxy) ServeTease(s *tease.Server) {
+	req, err := Read(s)
+	if err != nil {
+		s.Abort()
+		return
+	}
+
+	if req.Method != "CONNECT" {
+		p.fail(s, http.StatusMethodNotAllowed)
+		return
+	}
+	if p.Authorize != nil && !p.authorized(req) {
+		realm := p.Realm
+		if realm == "" {
+			realm = "proxy"
+		}
+		p.fail(s, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm="+strconv.Quote(realm))
+		return
+	}
+	host, portStr, err := net.SplitHostPort(req.Target)
+	port, perr := strconv.Atoi(portStr)
+	if err != nil || perr != nil || port < 1 || port > 65535 {
+		p.fail(s, http.StatusBadRequest)
+		return
+	}
+	if p.Allow == nil || !p.Allow(host, port) {
+		p.fail(s, http.StatusForbidden)
+		return
+	}
+
+	target, err := p.dial(req.Target)
+	if err != nil {
+		p.fail(s, http.StatusBadGateway)
+		return
+	}
+
+	// Consume the request head so only what follows it is passed on, then
+	// queue the answer to be flushed by the pipe.
+	if _, err := io.ReadFull(s, make([]byte, req.HeadLen)); err != nil {
+		target.Close()
+		s.Abort()
+		return
+	}
+	s.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err := s.Pipe(); err != nil {
+		target.Close()
+		s.Abort()
+		return
+	}
+	tease.Splice(s, target)
+}
+
+// Answer with an error status and close.
+func (p *ConnectProxy) fail(s *tease.Server, code int, headers ...string) {
+	if err := s.Pipe(); err != nil {
+		s.Abort()
+		return
+	}
+	writeStatus(s, code, headers...)
+	s.Close()
+}
+
+func (p *ConnectProxy) authorized(req *Request) bool {
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(prefix):]))
+	if err != nil {
+		return false
+	}
+	i := strings.IndexByte(string(raw), ':')
+	return i >= 0 && p.Authorize(string(raw[:i]), string(raw[i+1:]))
+}
+
+func (p *ConnectProxy) dial(addr string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial("tcp", addr)
+	}
+	return net.DialTimeout("tcp", addr, p.DialTimeout)
+}
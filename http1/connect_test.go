@@ -0,0 +1,120 @@
+package http1
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+func TestConnectProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	target := ln.Addr().String()
+	_, targetPort, _ := net.SplitHostPort(target)
+	echoPort, _ := strconv.Atoi(targetPort)
+
+	allowEcho := func(host string, port int) bool { return host == "127.0.0.1" && port == echoPort }
+	tests := []struct {
+		name  string
+		proxy *ConnectProxy
+		req   string
+		code  int
+	}{
+		{
+			name:  "allowed",
+			proxy: &ConnectProxy{Allow: allowEcho},
+			req:   "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n",
+			code:  http.StatusOK,
+		},
+		{
+			name:  "open by default",
+			proxy: &ConnectProxy{},
+			req:   "CONNECT " + target + " HTTP/1.1\r\n\r\n",
+			code:  http.StatusForbidden,
+		},
+		{
+			name:  "refused target",
+			proxy: &ConnectProxy{Allow: allowEcho},
+			req:   "CONNECT 127.0.0.1:1 HTTP/1.1\r\n\r\n",
+			code:  http.StatusForbidden,
+		},
+		{
+			name:  "bad port",
+			proxy: &ConnectProxy{Allow: allowEcho},
+			req:   "CONNECT 127.0.0.1:http HTTP/1.1\r\n\r\n",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "not connect",
+			proxy: &ConnectProxy{Allow: allowEcho},
+			req:   "GET / HTTP/1.1\r\n\r\n",
+			code:  http.StatusMethodNotAllowed,
+		},
+		{
+			name: "authorized",
+			proxy: &ConnectProxy{
+				Allow:     allowEcho,
+				Authorize: func(user, password string) bool { return user == "u" && password == "p:w" },
+			},
+			// u:p:w
+			req:  "CONNECT " + target + " HTTP/1.1\r\nProxy-Authorization: basic dTpwOnc=\r\n\r\n",
+			code: http.StatusOK,
+		},
+		{
+			name: "unauthorized",
+			proxy: &ConnectProxy{
+				Allow:     allowEcho,
+				Authorize: func(user, password string) bool { return false },
+			},
+			req:  "CONNECT " + target + " HTTP/1.1\r\nProxy-Authorization: Basic dTpwOnc=\r\n\r\n",
+			code: http.StatusProxyAuthRequired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer b.Close()
+			b.SetDeadline(time.Now().Add(2 * time.Second))
+			go tt.proxy.ServeTease(tease.NewServer(a))
+
+			// Bytes sent along with the request head go to the target
+			go b.Write([]byte(tt.req + "ping"))
+			br := bufio.NewReader(b)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.code {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			echo := make([]byte, 4)
+			if _, err := io.ReadFull(br, echo); err != nil || string(echo) != "ping" {
+				t.Errorf("target echoed %q, %v", echo, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+/*
+Package http1 detects HTTP/1.x requests on a teaser and parses the request
+line and headers out of the replay buffer.
+
+Nothing is consumed from the connection, so after routing on the Host header
+or the request path the teaser can be piped into net/http or forwarded to a
+backend as is.  Routing is decided on the first request of a connection,
+later requests on a kept alive connection follow it.
+*/
+package http1
@@ -0,0 +1,88 @@
+package http1
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// RedirectHTTPS is a tease.Handler for plain HTTP requests arriving on a port
+// configured for TLS.  It answers with a redirect to the https URL built from
+// the Host header and the request target, then closes the connection.
+type RedirectHTTPS struct {
+	// Status code of the redirect, http.StatusMovedPermanently or
+	// http.StatusPermanentRedirect.  Defaults to the latter, which keeps the
+	// request method.
+	Code int
+
+	// Port put in the https URL.  When empty or "443" the URL has no port.
+	Port string
+}
+
+// ServeTease reads the request head, pipes the teaser and writes the redirect
+// through it.
+func (h *RedirectHTTPS) ServeTease(s *tease.Server) {
+	req, err := Read(s)
+	if err != nil {
+		s.Abort()
+		return
+	}
+	if err := s.Pipe(); err != nil {
+		s.Abort()
+		return
+	}
+	defer s.Close()
+
+	location, ok := h.location(req)
+	if !ok {
+		writeStatus(s, http.StatusBadRequest)
+		return
+	}
+	code := h.Code
+	if code == 0 {
+		code = http.StatusPermanentRedirect
+	}
+	writeStatus(s, code, "Location: "+location)
+}
+
+// Build the https URL, refusing hosts which could not be put in a header.
+func (h *RedirectHTTPS) location(req *Request) (string, bool) {
+	host := req.Hostname()
+	if host == "" || strings.ContainsAny(host, "/\\@?#%\" \t\r\n") {
+		return "", false
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if h.Port != "" && h.Port != "443" {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), h.Port)
+	}
+
+	target := req.Target
+	if !strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme == "" {
+			return "", false
+		}
+		target = u.RequestURI()
+	}
+	if strings.ContainsAny(target, " \t\r\n") {
+		return "", false
+	}
+	return "https://" + host + target, true
+}
+
+// Write a bodiless response closing the connection, with the extra header
+// lines given.
+func writeStatus(s *tease.Server, code int, headers ...string) {
+	resp := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	for _, h := range headers {
+		resp += h + "\r\n"
+	}
+	resp += "Content-Length: 0\r\nConnection: close\r\n\r\n"
+	s.Write([]byte(resp))
+}
@@ -0,0 +1,243 @@
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	// Returned by Parse when the request head is not complete yet
+	ErrIncomplete = errors.New("http1: incomplete request head")
+	errMalformed  = errors.New("http1: malformed request")
+)
+
+// Request holds the head of an HTTP/1.x request.
+type Request struct {
+	Method string
+
+	// Request target as sent, in origin, absolute, authority or asterisk form
+	Target string
+
+	// Protocol version, such as "HTTP/1.1"
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+
+	// Host from the Host header, or from an absolute form target
+	Host string
+
+	Header http.Header
+
+	// Length of the request head, including the blank line ending it
+	HeadLen int
+}
+
+// Path returns the path of the request target, without the query.
+func (r *Request) Path() string {
+	if strings.HasPrefix(r.Target, "/") {
+		if i := strings.IndexByte(r.Target, '?'); i >= 0 {
+			return r.Target[:i]
+		}
+		return r.Target
+	}
+	if u, err := url.Parse(r.Target); err == nil && u.Scheme != "" {
+		if u.Path == "" {
+			return "/"
+		}
+		return u.Path
+	}
+	return ""
+}
+
+// HasToken reports whether the comma separated values of the header list the
+// token, ignoring case, as for Connection or Upgrade.
+func (r *Request) HasToken(name, token string) bool {
+	for _, v := range r.Header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Hostname returns the host without any port, in lower case.
+func (r *Request) Hostname() string {
+	host := strings.ToLower(r.Host)
+	if strings.HasPrefix(host, "[") {
+		if i := strings.IndexByte(host, ']'); i > 0 {
+			return host[1:i]
+		}
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && strings.Count(host, ":") == 1 {
+		return host[:i]
+	}
+	return host
+}
+
+// Parse reads the request head at the start of b.  If the head is not
+// complete yet ErrIncomplete is returned, unless what is there already shows
+// the input is not an HTTP/1.x request.
+func Parse(b []byte) (*Request, error) {
+	r, _, err := parse(b)
+	return r, err
+}
+
+func parse(b []byte) (*Request, int, error) {
+	eol := bytes.IndexByte(b, '\n')
+	if eol < 0 {
+		if !validPartialLine(b) {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+
+	req := &Request{Header: make(http.Header)}
+	if !req.parseRequestLine(trimCR(b[:eol])) {
+		return nil, 0, errMalformed
+	}
+
+	var last string
+	for off := eol + 1; ; {
+		n := bytes.IndexByte(b[off:], '\n')
+		if n < 0 {
+			return nil, len(b) + 1, ErrIncomplete
+		}
+		line := trimCR(b[off : off+n])
+		off += n + 1
+
+		if len(line) == 0 {
+			req.HeadLen = off
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Obsolete line folding continues the previous header
+			if last == "" {
+				return nil, 0, errMalformed
+			}
+			v := req.Header[last]
+			v[len(v)-1] += " " + string(bytes.TrimSpace(line))
+			continue
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 || !isToken(line[:i]) {
+			return nil, 0, errMalformed
+		}
+		last = textproto.CanonicalMIMEHeaderKey(string(line[:i]))
+		req.Header.Add(last, string(bytes.TrimSpace(line[i+1:])))
+	}
+
+	req.Host = req.Header.Get("Host")
+	if req.Host == "" {
+		if u, err := url.Parse(req.Target); err == nil && u.Host != "" {
+			req.Host = u.Host
+		} else if req.Method == "CONNECT" {
+			req.Host = req.Target
+		}
+	}
+	return req, 0, nil
+}
+
+// Parse "METHOD target HTTP/1.x"
+func (r *Request) parseRequestLine(line []byte) bool {
+	f := strings.Split(string(line), " ")
+	if len(f) != 3 || !isToken([]byte(f[0])) || f[1] == "" {
+		return false
+	}
+	major, minor, ok := http.ParseHTTPVersion(f[2])
+	if !ok || major != 1 {
+		return false
+	}
+	r.Method, r.Target, r.Proto = f[0], f[1], f[2]
+	r.ProtoMajor, r.ProtoMinor = major, minor
+	return true
+}
+
+// Check the beginning of a request line which has not been ended yet, so that
+// non HTTP input is turned down early.
+func validPartialLine(b []byte) bool {
+	sp := bytes.IndexByte(b, ' ')
+	if sp < 0 {
+		return isToken(b) || len(b) == 0
+	}
+	if sp == 0 || !isToken(b[:sp]) {
+		return false
+	}
+	rest := b[sp+1:]
+	if sp2 := bytes.IndexByte(rest, ' '); sp2 >= 0 {
+		proto := trimCR(rest[sp2+1:])
+		if !bytes.HasPrefix([]byte("HTTP/1."), proto) && !bytes.HasPrefix(proto, []byte("HTTP/1.")) {
+			return false
+		}
+	}
+	return true
+}
+
+func trimCR(b []byte) []byte {
+	if len(b) > 0 && b[len(b)-1] == '\r' {
+		return b[:len(b)-1]
+	}
+	return b
+}
+
+// Token characters of RFC 9110 section 5.6.2
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Probe matches a connection once a complete HTTP/1.x request head has been
+// buffered.
+var Probe = Matching(func(*Request) bool { return true })
+
+// Matching returns a probe matching the requests for which the function
+// returns true, once their head is complete.
+func Matching(match func(r *Request) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		req, need, err := parse(b)
+		return err == nil && match(req), need, err
+	})
+}
+
+// Route returns a probe matching the requests whose host matches the glob
+// pattern and whose path starts with the prefix.  An empty pattern or prefix
+// matches anything.  Globs follow path.Match and are compared against the
+// host name in lower case, without the port.
+func Route(hostGlob, pathPrefix string) tease.Probe {
+	hostGlob = strings.ToLower(hostGlob)
+	return Matching(func(r *Request) bool {
+		if hostGlob != "" {
+			if ok, _ := path.Match(hostGlob, r.Hostname()); !ok {
+				return false
+			}
+		}
+		return strings.HasPrefix(r.Path(), pathPrefix)
+	})
+}
+
+// Read waits for a complete request head on the teaser and parses it.  No
+// input is consumed.
+func Read(s *tease.Server) (*Request, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
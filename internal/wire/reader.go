@@ -0,0 +1,102 @@
+// Package wire reads the fixed size and length prefixed fields of the binary
+// messages parsed by the protocol packages.
+package wire
+
+import "encoding/binary"
+
+// Reader walks over a message.  Any read past the end of the input empties
+// the reader and marks it bad, so a parser can check Bad once at the end
+// instead of after every field.
+type Reader struct {
+	// Input left to read
+	B []byte
+
+	// Set once a read went past the end of the input
+	Bad bool
+}
+
+// Empty reports whether all the input has been read.
+func (r *Reader) Empty() bool {
+	return len(r.B) == 0
+}
+
+// Fail empties the reader and marks it bad, for fields found malformed.
+func (r *Reader) Fail() {
+	r.B, r.Bad = nil, true
+}
+
+// Bytes returns the next n bytes, or nil if there are not that many.
+func (r *Reader) Bytes(n int) []byte {
+	if n < 0 || n > len(r.B) {
+		r.Fail()
+		return nil
+	}
+	v := r.B[:n:n]
+	r.B = r.B[n:]
+	return v
+}
+
+// Sub returns a reader over the next n bytes, bad if this one is.
+func (r *Reader) Sub(n int) *Reader {
+	return &Reader{B: r.Bytes(n), Bad: r.Bad}
+}
+
+func (r *Reader) U8() uint8 {
+	v := r.Bytes(1)
+	if v == nil {
+		return 0
+	}
+	return v[0]
+}
+
+// Big endian integers
+
+func (r *Reader) U16() uint16 {
+	v := r.Bytes(2)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(v)
+}
+
+func (r *Reader) U32() uint32 {
+	v := r.Bytes(4)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v)
+}
+
+// Little endian integers
+
+func (r *Reader) U16LE() uint16 {
+	v := r.Bytes(2)
+	if v == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(v)
+}
+
+func (r *Reader) U24LE() uint32 {
+	v := r.Bytes(3)
+	if v == nil {
+		return 0
+	}
+	return uint32(v[0]) | uint32(v[1])<<8 | uint32(v[2])<<16
+}
+
+func (r *Reader) U32LE() uint32 {
+	v := r.Bytes(4)
+	if v == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(v)
+}
+
+func (r *Reader) U64LE() uint64 {
+	v := r.Bytes(8)
+	if v == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(v)
+}
@@ -0,0 +1,66 @@
+package tease
+
+import (
+	"net"
+	"sync"
+)
+
+// Listener is a net.Listener fed with connections by a Mux or a Handler, so
+// that servers expecting a listener can be used behind the teaser.
+type Listener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Create a new listener reporting the given address.
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Deliver hands a connection to the next Accept call, blocking until it is
+// accepted.  If the listener is closed the connection is closed instead.
+func (l *Listener) Deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for and returns the next delivered connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the delivery of connections to this listener.  Connections
+// delivered afterwards are closed.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address given when the listener was created, or a
+// placeholder when none was given.
+func (l *Listener) Addr() net.Addr {
+	if l.addr == nil {
+		return listenerAddr{}
+	}
+	return l.addr
+}
+
+// Address of a listener created without one.
+type listenerAddr struct{}
+
+func (listenerAddr) Network() string { return "tease" }
+func (listenerAddr) String() string  { return "tease" }
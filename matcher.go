@@ -0,0 +1,38 @@
+package tease
+
+import "bytes"
+
+// TLS matches a connection starting with a TLS handshake record.
+var TLS = ProbeFunc(func(b []byte) (Result, int) {
+	// ContentType handshake followed by a 3.x record version
+	switch {
+	case len(b) > 0 && b[0] != 0x16, len(b) > 1 && b[1] != 0x03:
+		return NoMatch, 0
+	case len(b) < 3:
+		return NeedMore, 0
+	case b[2] <= 0x04:
+		return Match, 0
+	}
+	return NoMatch, 0
+})
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "),
+	[]byte("DELETE "), []byte("CONNECT "), []byte("OPTIONS "),
+	[]byte("TRACE "), []byte("PATCH "),
+}
+
+// HTTP1 matches a connection starting with an HTTP/1.x request method.
+var HTTP1 = ProbeFunc(func(b []byte) (Result, int) {
+	result := NoMatch
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(b, m) {
+			return Match, 0
+		}
+		// Any further byte may rule out the methods still in the running
+		if bytes.HasPrefix(m, b) {
+			result = NeedMore
+		}
+	}
+	return result, 0
+})
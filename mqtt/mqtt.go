@@ -0,0 +1,319 @@
+/*
+Package mqtt detects MQTT clients from the CONNECT packet in the teaser
+buffer, for protocol levels 3.1, 3.1.1 and 5, so that the connections can be
+routed to their broker on the client identifier or username.
+
+The whole CONNECT packet is needed to get to the username, which follows the
+will message, so the teaser MaxBuffer must leave room for the largest will
+payload expected.
+*/
+package mqtt
+
+import (
+	"errors"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/internal/wire"
+)
+
+var (
+	// Returned by Parse while the packet is not complete
+	ErrIncomplete = errors.New("mqtt: incomplete packet")
+	errMalformed  = errors.New("mqtt: not an MQTT CONNECT packet")
+)
+
+const (
+	typeConnect = 1
+
+	// Connect flags
+	flagCleanStart = 1 << 1
+	flagWill       = 1 << 2
+	flagWillQoS    = 3 << 3
+	flagWillRetain = 1 << 5
+	flagPassword   = 1 << 6
+	flagUsername   = 1 << 7
+)
+
+// Protocol levels
+const (
+	Level31  = 3
+	Level311 = 4
+	Level5   = 5
+)
+
+// Connect is the CONNECT packet opening an MQTT session.
+type Connect struct {
+	// Protocol name, "MQTT", or "MQIsdp" for 3.1
+	ProtocolName string
+
+	// Protocol level, see Level31, Level311 and Level5
+	Level int
+
+	CleanStart bool
+	KeepAlive  uint16
+	ClientID   string
+
+	// Properties of an MQTT 5 connection
+	Properties *Properties
+
+	// Will message, if any
+	Will *Will
+
+	Username string
+
+	// Set when a password was given
+	HasPassword bool
+}
+
+// Will is the message published by the broker when the client goes away.
+type Will struct {
+	Topic   string
+	Payload []byte
+	QoS     int
+	Retain  bool
+
+	// Will properties of an MQTT 5 connection
+	Properties *Properties
+}
+
+// Properties holds the MQTT 5 properties of a CONNECT packet and of its will.
+type Properties struct {
+	// Connect properties
+	SessionExpiry       uint32
+	ReceiveMaximum      uint16
+	MaximumPacketSize   uint32
+	TopicAliasMaximum   uint16
+	RequestResponseInfo bool
+	RequestProblemInfo  bool
+	AuthMethod          string
+	AuthData            []byte
+
+	// Will properties
+	WillDelay       uint32
+	PayloadFormat   byte
+	MessageExpiry   uint32
+	ContentType     string
+	ResponseTopic   string
+	CorrelationData []byte
+
+	// User properties, in order, as a name may repeat
+	User []UserProperty
+}
+
+// UserProperty is a name and value pair set by the client.
+type UserProperty struct {
+	Name, Value string
+}
+
+// Version returns the protocol version for the level, such as "3.1.1".
+func (c *Connect) Version() string {
+	switch c.Level {
+	case Level31:
+		return "3.1"
+	case Level311:
+		return "3.1.1"
+	case Level5:
+		return "5"
+	}
+	return "unknown"
+}
+
+// Parse reads the CONNECT packet at the start of b.  ErrIncomplete is
+// returned while more bytes are needed.
+func Parse(b []byte) (*Connect, error) {
+	c, _, err := parse(b)
+	return c, err
+}
+
+func parse(b []byte) (*Connect, int, error) {
+	if len(b) < 1 {
+		return nil, 1, ErrIncomplete
+	}
+	if b[0] != typeConnect<<4 {
+		return nil, 0, errMalformed
+	}
+
+	// Remaining length
+	l, n := 0, 1
+	for ; ; n++ {
+		if n > 4 {
+			return nil, 0, errMalformed
+		}
+		if len(b) <= n {
+			if err := checkPrefix(b[n:]); err != nil {
+				return nil, 0, err
+			}
+			return nil, n + 1, ErrIncomplete
+		}
+		l |= int(b[n]&0x7f) << (7 * (n - 1))
+		if b[n]&0x80 == 0 {
+			n++
+			break
+		}
+	}
+	if len(b) < n+l {
+		if err := checkPrefix(b[n:]); err != nil {
+			return nil, 0, err
+		}
+		if len(b) < n+len("\x00\x06MQIsdp") {
+			// The protocol name may still rule the packet out
+			return nil, len(b) + 1, ErrIncomplete
+		}
+		return nil, n + l, ErrIncomplete
+	}
+
+	r := &wire.Reader{B: b[n : n+l]}
+	c := &Connect{ProtocolName: str(r), Level: int(r.U8())}
+	switch {
+	case c.ProtocolName == "MQIsdp" && c.Level == Level31:
+	case c.ProtocolName == "MQTT" && (c.Level == Level311 || c.Level == Level5):
+	default:
+		return nil, 0, errMalformed
+	}
+	flags := r.U8()
+	if flags&1 != 0 {
+		// Reserved flag
+		return nil, 0, errMalformed
+	}
+	c.CleanStart = flags&flagCleanStart != 0
+	c.KeepAlive = r.U16()
+	if c.Level == Level5 {
+		if c.Properties = parseProperties(r); c.Properties == nil {
+			return nil, 0, errMalformed
+		}
+	}
+
+	c.ClientID = str(r)
+	if flags&flagWill != 0 {
+		w := &Will{QoS: int(flags&flagWillQoS) >> 3, Retain: flags&flagWillRetain != 0}
+		if c.Level == Level5 {
+			if w.Properties = parseProperties(r); w.Properties == nil {
+				return nil, 0, errMalformed
+			}
+		}
+		w.Topic = str(r)
+		w.Payload = binary(r)
+		c.Will = w
+	}
+	if flags&flagUsername != 0 {
+		c.Username = str(r)
+	}
+	c.HasPassword = flags&flagPassword != 0
+	if c.HasPassword {
+		binary(r)
+	}
+	if r.Bad || !r.Empty() {
+		return nil, 0, errMalformed
+	}
+	return c, 0, nil
+}
+
+// Check the start of the variable header of an incomplete packet, so that
+// other protocols are not held up until the whole packet length is read.
+func checkPrefix(b []byte) error {
+	for _, name := range []string{"\x00\x04MQTT", "\x00\x06MQIsdp"} {
+		n := len(name)
+		if len(b) < n {
+			n = len(b)
+		}
+		if string(b[:n]) == name[:n] {
+			return nil
+		}
+	}
+	return errMalformed
+}
+
+// Read a property list, nil if malformed.
+func parseProperties(r *wire.Reader) *Properties {
+	p := &Properties{}
+	pr := r.Sub(varint(r))
+	for !pr.Empty() {
+		switch id := pr.U8(); id {
+		case 0x01:
+			p.PayloadFormat = pr.U8()
+		case 0x02:
+			p.MessageExpiry = pr.U32()
+		case 0x03:
+			p.ContentType = str(pr)
+		case 0x08:
+			p.ResponseTopic = str(pr)
+		case 0x09:
+			p.CorrelationData = binary(pr)
+		case 0x11:
+			p.SessionExpiry = pr.U32()
+		case 0x15:
+			p.AuthMethod = str(pr)
+		case 0x16:
+			p.AuthData = binary(pr)
+		case 0x17:
+			p.RequestProblemInfo = pr.U8() != 0
+		case 0x18:
+			p.WillDelay = pr.U32()
+		case 0x19:
+			p.RequestResponseInfo = pr.U8() != 0
+		case 0x21:
+			p.ReceiveMaximum = pr.U16()
+		case 0x22:
+			p.TopicAliasMaximum = pr.U16()
+		case 0x26:
+			p.User = append(p.User, UserProperty{Name: str(pr), Value: str(pr)})
+		case 0x27:
+			p.MaximumPacketSize = pr.U32()
+		default:
+			// Not allowed in a CONNECT packet
+			return nil
+		}
+	}
+	if r.Bad || pr.Bad {
+		return nil
+	}
+	return p
+}
+
+// Matching returns a probe matching the CONNECT packets for which the
+// function returns true.
+func Matching(match func(c *Connect) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		c, need, err := parse(b)
+		return err == nil && match(c), need, err
+	})
+}
+
+// Probe matches any MQTT client.
+var Probe = Matching(func(*Connect) bool { return true })
+
+// ClientID returns a probe matching the clients whose identifier starts with
+// any of the given prefixes.
+func ClientID(prefixes ...string) tease.Probe {
+	return Matching(func(c *Connect) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(c.ClientID, p) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Username returns a probe matching the clients connecting as any of the
+// given usernames.
+func Username(names ...string) tease.Probe {
+	return Matching(func(c *Connect) bool {
+		for _, n := range names {
+			if c.Username == n {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Read waits for the CONNECT packet on the teaser and parses it.  No input is
+// consumed.
+func Read(s *tease.Server) (*Connect, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
@@ -0,0 +1,124 @@
+package mqtt
+
+import (
+	"reflect"
+	"testing"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// CONNECT packet around the given variable header and payload.
+func connect(parts ...string) []byte {
+	var body []byte
+	for _, p := range parts {
+		body = append(body, p...)
+	}
+	b := []byte{typeConnect << 4}
+	l := len(body)
+	for {
+		c := byte(l & 0x7f)
+		if l >>= 7; l > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if l == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+// String with its 16 bit length prefix
+func s16(s string) string {
+	return string([]byte{byte(len(s) >> 8), byte(len(s))}) + s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want Connect
+	}{
+		{"3.1.1", connect(s16("MQTT"), "\x04\x02\x00\x3c", s16("sensor-1")), Connect{
+			ProtocolName: "MQTT", Level: Level311, CleanStart: true, KeepAlive: 60, ClientID: "sensor-1",
+		}},
+		{"3.1 with credentials", connect(s16("MQIsdp"), "\x03\xc0\x00\x0a", s16("c"), s16("fleet"), s16("pw")), Connect{
+			ProtocolName: "MQIsdp", Level: Level31, KeepAlive: 10, ClientID: "c", Username: "fleet", HasPassword: true,
+		}},
+		{"5 with will", connect(s16("MQTT"), "\x05\x2e\x00\x1e",
+			"\x0c\x11\x00\x00\x0e\x10\x26"+s16("k")+s16("v"),
+			s16("car-7"),
+			"\x05\x18\x00\x00\x00\x05", s16("cars/7/status"), s16("gone"),
+		), Connect{
+			ProtocolName: "MQTT", Level: Level5, CleanStart: true, KeepAlive: 30, ClientID: "car-7",
+			Properties: &Properties{SessionExpiry: 3600, User: []UserProperty{{"k", "v"}}},
+			Will: &Will{
+				Topic: "cars/7/status", Payload: []byte("gone"), QoS: 1, Retain: true,
+				Properties: &Properties{WillDelay: 5},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*c, tt.want) {
+				t.Errorf("got %+v\nwant %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	full := connect(s16("MQTT"), "\x04\x02\x00\x3c", s16("sensor-1"))
+	tests := []struct {
+		name string
+		in   []byte
+		need int
+	}{
+		{"empty", nil, 1},
+		{"fixed header", full[:1], 2},
+		{"partial name", full[:5], 6},
+		{"partial body", full[:12], len(full)},
+		{"not a connect", []byte{0x30, 0x00}, 0},
+		{"remaining length too long", []byte{0x10, 0xff, 0xff, 0xff, 0xff, 0x7f}, 0},
+		{"other protocol name", connect(s16("AMQP")), 0},
+		{"partial other name", []byte{0x10, 0x0c, 0x00, 0x04, 'M', 'X'}, 0},
+		{"wrong level", connect(s16("MQTT"), "\x03\x02\x00\x3c", s16("c")), 0},
+		{"reserved flag", connect(s16("MQTT"), "\x04\x03\x00\x3c", s16("c")), 0},
+		{"trailing bytes", connect(s16("MQTT"), "\x04\x02\x00\x3c", s16("c"), "x"), 0},
+		{"missing username", connect(s16("MQTT"), "\x04\x82\x00\x3c", s16("c")), 0},
+		{"publish property", connect(s16("MQTT"), "\x05\x02\x00\x3c", "\x03\x23\x00\x01", s16("c")), 0},
+	}
+	for _, tt := range tests {
+		c, need, err := parse(tt.in)
+		if err == nil || need != tt.need || tt.need > 0 && err != ErrIncomplete {
+			t.Errorf("%s: got %+v, %d, %v, want need %d", tt.name, c, need, err, tt.need)
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	b := connect(s16("MQTT"), "\x04\x82\x00\x3c", s16("sensor-1"), s16("fleet"))
+	tests := []struct {
+		name  string
+		probe tease.Probe
+		want  tease.Result
+	}{
+		{"any", Probe, tease.Match},
+		{"client id", ClientID("gw-", "sensor-"), tease.Match},
+		{"other client id", ClientID("gw-"), tease.NoMatch},
+		{"username", Username("fleet"), tease.Match},
+		{"other username", Username("admin"), tease.NoMatch},
+	}
+	for _, tt := range tests {
+		if r, _ := tt.probe.Probe(b); r != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, r, tt.want)
+		}
+		if r, _ := tt.probe.Probe(b[:len(b)-1]); r != tease.NeedMore {
+			t.Errorf("%s: %v on a partial packet", tt.name, r)
+		}
+	}
+}
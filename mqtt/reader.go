@@ -0,0 +1,28 @@
+package mqtt
+
+import "github.com/pschou/go-tease/internal/wire"
+
+// Variable byte integer, seven bits per byte with the high bit set on all
+// but the last of at most four bytes
+func varint(r *wire.Reader) int {
+	v := 0
+	for i := 0; i < 4; i++ {
+		c := r.U8()
+		v |= int(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+	r.Fail()
+	return 0
+}
+
+// Binary data with a 16 bit length prefix, copied out of the input
+func binary(r *wire.Reader) []byte {
+	return append([]byte(nil), r.Bytes(int(r.U16()))...)
+}
+
+// UTF-8 string with a 16 bit length prefix
+func str(r *wire.Reader) string {
+	return string(r.Bytes(int(r.U16())))
+}
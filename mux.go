@@ -0,0 +1,269 @@
+package tease
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Mux splits the connections accepted on a single net.Listener over several
+// child listeners, based on the protocol detected at the start of each
+// connection.  Each accepted connection is wrapped in a Server and fed to the
+// probes of all the routes at once, with priority given to the routes in the
+// order they were added.  The winning route receives the piped connection on
+// its child listener, or the teaser itself when the route has a Handler.
+//
+// Detection runs in its own goroutine per connection, so a slow client will
+// not hold up the Accept loop for everyone else.
+type Mux struct {
+	ln net.Listener
+
+	// Maximum number of bytes to be buffered per connection while detecting.
+	// If left at zero the Server default is used.
+	MaxBuffer int
+
+	// Time allowed for a client to send enough bytes for detection.  When the
+	// timeout is reached the connection is dropped.  The deadline stays in
+	// place while a Handler negotiates on the teaser, and is lifted when the
+	// teaser is piped.  A zero value means the detection will not time out.
+	ReadTimeout time.Duration
+
+	// Networks of the load balancers trusted to send a PROXY protocol header.
+	// The header is stripped before detection, see Server.ReadProxyHeader.
+	TrustedProxies []*net.IPNet
+
+	// Time to wait for the client to send its first byte.  When the window
+	// passes in silence, the connection goes to the route registered with
+	// Silent or HandleSilent, as the client is waiting on a server-first
+	// protocol like SMTP, FTP or MySQL.  A zero value disables the window.
+	SilenceTimeout time.Duration
+
+	mu      sync.Mutex
+	routes  []*muxRoute
+	silence *muxRoute
+	done    chan struct{}
+	once    sync.Once
+}
+
+type muxRoute struct {
+	probes []Probe
+	l      *Listener
+	h      Handler
+}
+
+// Create a new multiplexer on top of the given listener.  Call Serve() after
+// the routes are registered to start accepting connections.
+func NewMux(ln net.Listener) *Mux {
+	return &Mux{
+		ln:   ln,
+		done: make(chan struct{}),
+	}
+}
+
+// Match returns a listener which will receive the connections matched by any
+// of the given probes.
+func (m *Mux) Match(probes ...Probe) net.Listener {
+	l := NewListener(m.ln.Addr())
+	m.mu.Lock()
+	m.routes = append(m.routes, &muxRoute{probes: probes, l: l})
+	m.mu.Unlock()
+	return l
+}
+
+// Handle registers a handler for the connections matched by any of the given
+// probes.  The handler is called in the detection goroutine with the teaser
+// still in tease mode.
+func (m *Mux) Handle(h Handler, probes ...Probe) {
+	m.mu.Lock()
+	m.routes = append(m.routes, &muxRoute{probes: probes, h: h})
+	m.mu.Unlock()
+}
+
+// Deny closes the connections matched by any of the given probes.  As routes
+// are given priority in order, Deny is best called before the other routes
+// are registered.
+func (m *Mux) Deny(probes ...Probe) {
+	m.Handle(HandlerFunc(func(s *Server) { s.Abort() }), probes...)
+}
+
+// Silent returns a listener which will receive the connections on which the
+// client sent nothing within SilenceTimeout.  The server is expected to speak
+// first on these connections.
+func (m *Mux) Silent() net.Listener {
+	l := NewListener(m.ln.Addr())
+	m.setSilence(&muxRoute{l: l})
+	return l
+}
+
+// HandleSilent registers a handler for the connections on which the client
+// sent nothing within SilenceTimeout.  The handler is called in the detection
+// goroutine with the teaser still in tease mode, so the greeting can be sent
+// with WriteThrough and the reply detected as usual.
+func (m *Mux) HandleSilent(h Handler) {
+	m.setSilence(&muxRoute{h: h})
+}
+
+func (m *Mux) setSilence(r *muxRoute) {
+	m.mu.Lock()
+	if m.silence != nil && m.silence.l != nil {
+		m.silence.l.Close()
+	}
+	m.silence = r
+	m.mu.Unlock()
+}
+
+// Any returns a listener which will receive all the connections not claimed
+// by a route registered before it.
+func (m *Mux) Any() net.Listener {
+	return m.Match(anyProbe)
+}
+
+// Serve accepts connections from the underlying listener and hands them off
+// for detection.  Serve always returns a non-nil error, after which all the
+// child listeners are closed.
+func (m *Mux) Serve() error {
+	defer m.closeRoutes()
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			select {
+			case <-m.done:
+				return net.ErrClosed
+			default:
+			}
+			return err
+		}
+		go m.serve(conn)
+	}
+}
+
+// Close stops the underlying listener and all the child listeners.  Handlers
+// implementing io.Closer, such as a tls.Router with its own listeners, are
+// closed too.
+func (m *Mux) Close() error {
+	m.once.Do(func() { close(m.done) })
+	m.closeRoutes()
+	return m.ln.Close()
+}
+
+// Addr returns the listener's network address.
+func (m *Mux) Addr() net.Addr {
+	return m.ln.Addr()
+}
+
+func (m *Mux) closeRoutes() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.routes {
+		r.close()
+	}
+	if m.silence != nil {
+		m.silence.close()
+	}
+}
+
+func (r *muxRoute) close() {
+	if r.l != nil {
+		r.l.Close()
+	}
+	if c, ok := r.h.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (m *Mux) serve(conn net.Conn) {
+	s := NewServer(conn)
+	if m.MaxBuffer > 0 {
+		s.MaxBuffer = m.MaxBuffer
+	}
+	var deadline time.Time
+	if m.ReadTimeout > 0 {
+		deadline = time.Now().Add(m.ReadTimeout)
+		conn.SetReadDeadline(deadline)
+		s.detectDeadline = true
+	}
+
+	// A load balancer sends its PROXY header straight away, so the silence
+	// window is checked both before and after the header.
+	r, err := m.silent(s, deadline)
+	if r == nil && err == nil && len(m.TrustedProxies) > 0 {
+		s.TrustedProxies = m.TrustedProxies
+		if _, err = s.ReadProxyHeader(); err == nil {
+			r, err = m.silent(s, deadline)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if r == nil {
+		r = m.match(s)
+	}
+	if r == nil {
+		conn.Close()
+		return
+	}
+
+	if r.h != nil {
+		r.h.ServeTease(s)
+		return
+	}
+	if err := s.Pipe(); err != nil {
+		conn.Close()
+		return
+	}
+	r.l.Deliver(s)
+}
+
+// Wait up to SilenceTimeout for the client to speak and return the silence
+// route if it did not.  The read deadline is restored afterwards.
+func (m *Mux) silent(s *Server, deadline time.Time) (*muxRoute, error) {
+	m.mu.Lock()
+	r := m.silence
+	m.mu.Unlock()
+	if r == nil || m.SilenceTimeout <= 0 || len(s.Buffered()) > 0 {
+		return nil, nil
+	}
+
+	// When the ReadTimeout deadline comes first, running into it is a
+	// timeout and not silence
+	window := time.Now().Add(m.SilenceTimeout)
+	silence := true
+	if !deadline.IsZero() && deadline.Before(window) {
+		window, silence = deadline, false
+	}
+	s.conn.SetReadDeadline(window)
+	_, err := s.Detect(spokeProbe)
+	s.conn.SetReadDeadline(deadline)
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && silence && len(s.Buffered()) == 0 {
+		s.mu.Lock()
+		s.err = nil
+		s.mu.Unlock()
+		return r, nil
+	}
+	return nil, err
+}
+
+// Run the probes of all routes together and return the route which matched.
+func (m *Mux) match(s *Server) *muxRoute {
+	m.mu.Lock()
+	routes := m.routes
+	m.mu.Unlock()
+
+	var probes []Probe
+	var owner []*muxRoute
+	for _, r := range routes {
+		for _, p := range r.probes {
+			probes = append(probes, p)
+			owner = append(owner, r)
+		}
+	}
+
+	i, err := s.Detect(probes...)
+	if err != nil {
+		return nil
+	}
+	return owner[i]
+}
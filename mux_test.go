@@ -0,0 +1,302 @@
+package tease
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Mux on a loopback listener, serving until the test ends.
+func testMux(t *testing.T) *Mux {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(ln)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// Connect to the mux, sending the chunks one write at a time.
+func dialMux(t *testing.T, m *Mux, chunks ...string) net.Conn {
+	t.Helper()
+	go m.Serve()
+	conn, err := net.Dial("tcp", m.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	for _, c := range chunks {
+		if _, err := conn.Write([]byte(c)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return conn
+}
+
+// Handler reporting its name on the channel and answering with it.
+func namedHandler(name string, served chan<- string) Handler {
+	return HandlerFunc(func(s *Server) {
+		served <- name
+		s.Pipe()
+		s.Write([]byte(name))
+		s.Close()
+	})
+}
+
+func TestMuxRouting(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes func(m *Mux, served chan<- string)
+		chunks []string
+		want   string
+	}{
+		{
+			name: "first route wins",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("tls", served), TLS)
+				m.Handle(namedHandler("first", served), HTTP1)
+				m.Handle(namedHandler("second", served), HTTP1)
+			},
+			chunks: []string{"GET / HTTP/1.1\r\n\r\n"},
+			want:   "first",
+		},
+		{
+			name: "earlier route decides first",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("long", served), &lengthProbe{at: 6, result: Match})
+				m.Handle(namedHandler("any", served), spokeProbe)
+			},
+			chunks: []string{"abc", "def"},
+			want:   "long",
+		},
+		{
+			name: "later route after a refusal",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("long", served), &lengthProbe{at: 6, result: NoMatch})
+				m.Handle(namedHandler("any", served), spokeProbe)
+			},
+			chunks: []string{"abc", "def"},
+			want:   "any",
+		},
+		{
+			name: "any of the probes of a route",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("web", served), TLS, HTTP1)
+			},
+			chunks: []string{"\x16\x03\x01"},
+			want:   "web",
+		},
+		{
+			name: "deny first",
+			routes: func(m *Mux, served chan<- string) {
+				m.Deny(HTTP1)
+				m.Handle(namedHandler("http", served), HTTP1)
+			},
+			chunks: []string{"GET / HTTP/1.1\r\n\r\n"},
+		},
+		{
+			name: "deny after the route",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("http", served), HTTP1)
+				m.Deny(HTTP1)
+			},
+			chunks: []string{"GET / HTTP/1.1\r\n\r\n"},
+			want:   "http",
+		},
+		{
+			name: "no match",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("tls", served), TLS)
+			},
+			chunks: []string{"SSH-2.0-x\r\n"},
+		},
+		{
+			name: "any for the rest",
+			routes: func(m *Mux, served chan<- string) {
+				m.Handle(namedHandler("tls", served), TLS)
+				m.Handle(namedHandler("rest", served), anyProbe)
+			},
+			chunks: []string{"SSH-2.0-x\r\n"},
+			want:   "rest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMux(t)
+			served := make(chan string, 1)
+			tt.routes(m, served)
+			conn := dialMux(t, m, tt.chunks...)
+
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("answered %q, want %q", got, tt.want)
+			}
+			select {
+			case name := <-served:
+				if name != tt.want {
+					t.Errorf("served by %q, want %q", name, tt.want)
+				}
+			default:
+				if tt.want != "" {
+					t.Errorf("not served")
+				}
+			}
+		})
+	}
+}
+
+// A Match listener gets the piped connection, input replayed from the start.
+func TestMuxMatch(t *testing.T) {
+	m := testMux(t)
+	m.Deny(TLS)
+	web := m.Match(HTTP1)
+	conn := dialMux(t, m, "GE", "T / HTTP/1.1\r\n")
+
+	c, err := web.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got := make([]byte, 16)
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	c.Write([]byte("HTTP/1.1 204 No Content\r\n"))
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "HTTP/1.1 204" {
+		t.Errorf("client got %q, %v", reply, err)
+	}
+}
+
+// Closing the mux closes the child listeners.
+func TestMuxClose(t *testing.T) {
+	m := testMux(t)
+	l := m.Match(HTTP1)
+	errs := make(chan error, 1)
+	go func() { errs <- m.Serve() }()
+	m.Close()
+	if err := <-errs; err != net.ErrClosed {
+		t.Errorf("Serve() = %v", err)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Errorf("child listener still accepting")
+	}
+}
+
+// Accept connections from the listener onto a channel.
+func acceptAll(l net.Listener) <-chan net.Conn {
+	conns := make(chan net.Conn, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	return conns
+}
+
+func TestMuxSilence(t *testing.T) {
+	m := testMux(t)
+	m.SilenceTimeout = 50 * time.Millisecond
+	m.ReadTimeout = 2 * time.Second
+	m.HandleSilent(HandlerFunc(func(s *Server) {
+		s.WriteThrough([]byte("220 ready\r\n"))
+		if _, err := s.Detect(spokeProbe); err != nil {
+			s.Abort()
+			return
+		}
+		s.Pipe()
+		s.Write([]byte("250 " + string(s.Buffered())))
+		s.Close()
+	}))
+	m.Deny(TLS)
+
+	start := time.Now()
+	conn := dialMux(t, m)
+	greeting := make([]byte, 11)
+	if _, err := io.ReadFull(conn, greeting); err != nil || string(greeting) != "220 ready\r\n" {
+		t.Fatalf("greeting %q, %v", greeting, err)
+	}
+	if d := time.Since(start); d < m.SilenceTimeout {
+		t.Errorf("greeted after %v", d)
+	}
+
+	// The reply is detected as usual once the window is over, even if it
+	// looks like another protocol
+	conn.Write([]byte("\x16\x03\x01"))
+	if got, err := io.ReadAll(conn); err != nil || string(got) != "250 \x16\x03\x01" {
+		t.Errorf("reply %q, %v", got, err)
+	}
+}
+
+func TestMuxTalker(t *testing.T) {
+	m := testMux(t)
+	m.SilenceTimeout = time.Second
+	silent := acceptAll(m.Silent())
+	web := acceptAll(m.Match(HTTP1))
+	dialMux(t, m, "GET / HTTP/1.1\r\n")
+
+	select {
+	case c := <-web:
+		c.Close()
+	case <-silent:
+		t.Fatal("talker taken for silent")
+	case <-time.After(m.SilenceTimeout / 2):
+		t.Fatal("talker held up by the silence window")
+	}
+}
+
+// When the ReadTimeout comes before the end of the silence window, a silent
+// client has timed out rather than been silent.
+func TestMuxReadTimeoutFirst(t *testing.T) {
+	m := testMux(t)
+	m.SilenceTimeout = time.Second
+	m.ReadTimeout = 50 * time.Millisecond
+	silent := acceptAll(m.Silent())
+
+	start := time.Now()
+	conn := dialMux(t, m)
+	if got, err := io.ReadAll(conn); err != nil || len(got) > 0 {
+		t.Errorf("read %q, %v", got, err)
+	}
+	if d := time.Since(start); d >= m.SilenceTimeout {
+		t.Errorf("dropped after %v", d)
+	}
+	select {
+	case <-silent:
+		t.Error("timed out client taken for silent")
+	default:
+	}
+}
+
+// A load balancer sends its PROXY header at once, while the client behind it
+// is silent.
+func TestMuxProxyThenSilence(t *testing.T) {
+	m := testMux(t)
+	m.SilenceTimeout = 50 * time.Millisecond
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	m.TrustedProxies = []*net.IPNet{loopback}
+	silent := acceptAll(m.Silent())
+	dialMux(t, m, "PROXY TCP4 192.0.2.1 192.0.2.2 40000 25\r\n")
+
+	select {
+	case c := <-silent:
+		defer c.Close()
+		if got := c.RemoteAddr().String(); got != "192.0.2.1:40000" {
+			t.Errorf("RemoteAddr() = %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not taken for silent")
+	}
+}
@@ -0,0 +1,12 @@
+/*
+Package mysql emulates the start of the MySQL handshake on a teaser.  MySQL
+clients say nothing until the server greets them, so the teaser would come
+from a Mux silence route.
+
+A synthetic HandshakeV10 is sent with a write through, then the client's
+HandshakeResponse41 is read, exposing the username, database and capability
+flags for routing.  After an SSLRequest teasing continues on the TLS
+ClientHello instead.  The chosen backend is then taken through its own
+handshake before the connection is spliced to it.
+*/
+package mysql
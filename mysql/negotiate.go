@@ -0,0 +1,252 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	errNoSSL        = errors.New("mysql: SSLRequest while SSL was not offered")
+	errBackend      = errors.New("mysql: backend refused the connection")
+	errCapabilities = errors.New("mysql: backend lacks capabilities used by the client")
+	errPluginAuth   = errors.New("mysql: client does not support authentication switches")
+)
+
+// Plugin named in the response relayed on a handoff.  No account uses it, so
+// the backend answers with an AuthSwitchRequest carrying its own scramble.
+const handoffPlugin = "tease_handoff"
+
+// Capabilities which change the framing of what follows the handshake, so
+// the backend must have all of those the client asked for.
+const framingCapabilities = ClientProtocol41 | ClientSSL | ClientCompress |
+	ClientSecureConnection | ClientMultiResults | ClientPSMultiResults |
+	ClientPluginAuth | ClientSessionTrack | ClientDeprecateEOF
+
+// Handshake sent when the Router has none, with capabilities common to the
+// MySQL 5.7 and later and MariaDB servers.
+var defaultHandshake = Handshake{
+	ServerVersion: "8.0.0-tease",
+	Capabilities: ClientLongPassword | ClientFoundRows | ClientLongFlag |
+		ClientConnectWithDB | ClientProtocol41 | ClientInteractive |
+		ClientTransactions | ClientSecureConnection | ClientMultiStatements |
+		ClientMultiResults | ClientPSMultiResults | ClientPluginAuth |
+		ClientConnectAttrs | ClientPluginAuthLenencClientData,
+	Charset:    45, // utf8mb4_general_ci
+	Status:     2,  // autocommit
+	AuthPlugin: "caching_sha2_password",
+}
+
+// Session is the outcome of the handshake with a client.
+type Session struct {
+	// Handshake sent to the client
+	Handshake *Handshake
+
+	// Set when the client sent an SSLRequest and is now starting TLS
+	TLS bool
+
+	// HandshakeResponse41 of the client, or its SSLRequest when TLS is set,
+	// which only carries the capability flags
+	Response *Response
+
+	// Teaser the handshake took place on, still in tease mode with its input
+	// committed up to the end of the client packet
+	Conn *tease.Server
+}
+
+// Negotiate greets the client on the teaser with hs and reads its answer.
+// The handshake is sent with a write through, and the client packet is
+// consumed and committed, so that teasing continues on what follows: the TLS
+// ClientHello after an SSLRequest can be inspected with tls.Read as usual.
+//
+// A scramble and a connection ID are generated when hs has none, and
+// ClientSSL is only announced when acceptSSL is set.
+//
+// Beware that the client sees the connection ID of the handshake and not the
+// thread ID the backend gives it later.  A client cancelling a query with KILL
+// QUERY on another connection names an ID the backend does not know, so the
+// generated IDs are taken at random well above the thread IDs servers hand
+// out, for the KILL to fail rather than hit the session of someone else.
+func Negotiate(s *tease.Server, hs *Handshake, acceptSSL bool) (*Session, error) {
+	h := *hs
+	if len(h.AuthData) == 0 {
+		h.AuthData = scramble()
+	}
+	if h.ConnectionID == 0 {
+		h.ConnectionID = randomConnectionID()
+	}
+	if acceptSSL {
+		h.Capabilities |= ClientSSL
+	} else {
+		h.Capabilities &^= ClientSSL
+	}
+	sess := &Session{Handshake: &h, Conn: s}
+
+	s.Replay()
+	if _, err := s.WriteThrough(h.Packet()); err != nil {
+		return nil, err
+	}
+	m, err := Read(s)
+	if err != nil {
+		return nil, err
+	}
+	if m.SSLRequest && !acceptSSL {
+		return nil, errNoSSL
+	}
+	if _, err := io.ReadFull(s, make([]byte, m.Len)); err != nil {
+		return nil, err
+	}
+	// Commit the packet without sending anything, the client waits for
+	// either the TLS handshake or the authentication to go on
+	if _, err := s.WriteThrough(nil); err != nil {
+		return nil, err
+	}
+	sess.TLS = m.SSLRequest
+	sess.Response = m
+	return sess, nil
+}
+
+// Prepare takes a backend through its handshake so that the teased
+// connection can then be spliced to it.  The greeting of the backend is read
+// and checked to support the capabilities the client asked for.
+//
+// For a TLS session the SSLRequest is relayed, so the TLS session is passed
+// through end to end.  The client authenticates inside it against the
+// scramble of the synthetic handshake, so only the plugins which do not rely
+// on the scramble, such as mysql_clear_password, get through.
+//
+// Otherwise the response is relayed as a re-handshake handoff: the
+// authentication response, computed on the synthetic scramble, is dropped and
+// the backend is made to send an AuthSwitchRequest with its own scramble,
+// which the client answers once spliced.  The client must support
+// ClientPluginAuth.
+func (sess *Session) Prepare(backend net.Conn) error {
+	h, err := readHandshake(backend)
+	if err != nil {
+		return err
+	}
+	if sess.Response.Capabilities&^h.Capabilities&framingCapabilities != 0 {
+		return errCapabilities
+	}
+	if sess.TLS {
+		_, err = backend.Write(sess.Response.Packet(sess.Response.Seq))
+		return err
+	}
+	if sess.Response.Capabilities&ClientPluginAuth == 0 {
+		return errPluginAuth
+	}
+	m := *sess.Response
+	m.AuthPlugin = handoffPlugin
+	m.AuthResponse = nil
+	_, err = backend.Write(m.Packet(m.Seq))
+	return err
+}
+
+// Read the HandshakeV10 greeting of a backend.
+func readHandshake(conn net.Conn) (*Handshake, error) {
+	hdr := make([]byte, headerLen)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, err
+	}
+	l := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	if l == 0 || l > maxHandshakeLen {
+		return nil, errMalformed
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	if payload[0] == 0xff {
+		// ERR packet, such as too many connections
+		return nil, errBackend
+	}
+	return ParseHandshake(payload)
+}
+
+// Random scramble of 20 printable bytes, as the servers send, so that it
+// never contains the NUL terminator.
+func scramble() []byte {
+	b := make([]byte, 20)
+	rand.Read(b)
+	for i := range b {
+		b[i] = 0x21 + b[i]%0x5e
+	}
+	return b
+}
+
+// Random connection ID in the upper half of the range.
+func randomConnectionID() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return binary.LittleEndian.Uint32(b) | 1<<31
+}
+
+// Router is a tease.Handler which greets MySQL clients with a synthetic
+// handshake, then forwards them to the backend picked by Route.  The backend
+// goes through its own handshake before the connection is spliced, see
+// Session.Prepare.
+//
+// MySQL is a server-first protocol, so the Router would typically be
+// registered with Mux.HandleSilent.
+type Router struct {
+	// Handshake sent to clients, a fresh scramble and connection ID are used
+	// for each connection when left empty.  The capabilities should be
+	// supported by all the backends.  When nil, a handshake with the
+	// capabilities common to the current MySQL and MariaDB servers is sent.
+	Handshake *Handshake
+
+	// Accept SSLRequests.  The TLS session is passed through to the backend,
+	// which must accept SSL too.
+	AcceptSSL bool
+
+	// Pick the backend address for a session, or return an empty address to
+	// drop it.  For a TLS session the ClientHello can be inspected on
+	// sess.Conn, for instance with tls.Read, otherwise sess.Response holds the
+	// username and database.
+	Route func(sess *Session) string
+
+	// Time allowed to reach the backend after the client sent its handshake
+	// response, counted against the connect timeout of the client waiting on
+	// its login.  Zero leaves it to the operating system.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the backends.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// PROXY protocol version of the header sent to the backends ahead of the
+	// replayed handshake, zero for none.
+	ProxyProtocol int
+}
+
+// ServeTease negotiates, picks the backend and splices the connection to it.
+func (r *Router) ServeTease(s *tease.Server) {
+	hs := r.Handshake
+	if hs == nil {
+		hs = &defaultHandshake
+	}
+	sess, err := Negotiate(s, hs, r.AcceptSSL)
+	if err != nil {
+		s.Abort()
+		return
+	}
+	addr := r.Route(sess)
+	if addr == "" {
+		s.Abort()
+		return
+	}
+
+	f := &tease.Forwarder{
+		Addr:          addr,
+		DialTimeout:   r.DialTimeout,
+		Dial:          r.Dial,
+		ProxyProtocol: r.ProxyProtocol,
+		Protocol:      "mysql",
+		Prepare:       sess.Prepare,
+	}
+	f.ServeTease(s)
+}
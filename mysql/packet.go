@@ -0,0 +1,371 @@
+package mysql
+
+import (
+	"bytes"
+	"errors"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/internal/wire"
+)
+
+var (
+	// Returned by Parse while the packet is not complete
+	ErrIncomplete = errors.New("mysql: incomplete packet")
+	errMalformed  = errors.New("mysql: not a MySQL handshake packet")
+)
+
+// Capability flags
+const (
+	ClientLongPassword               = 1 << 0
+	ClientFoundRows                  = 1 << 1
+	ClientLongFlag                   = 1 << 2
+	ClientConnectWithDB              = 1 << 3
+	ClientNoSchema                   = 1 << 4
+	ClientCompress                   = 1 << 5
+	ClientODBC                       = 1 << 6
+	ClientLocalFiles                 = 1 << 7
+	ClientIgnoreSpace                = 1 << 8
+	ClientProtocol41                 = 1 << 9
+	ClientInteractive                = 1 << 10
+	ClientSSL                        = 1 << 11
+	ClientIgnoreSigpipe              = 1 << 12
+	ClientTransactions               = 1 << 13
+	ClientSecureConnection           = 1 << 15
+	ClientMultiStatements            = 1 << 16
+	ClientMultiResults               = 1 << 17
+	ClientPSMultiResults             = 1 << 18
+	ClientPluginAuth                 = 1 << 19
+	ClientConnectAttrs               = 1 << 20
+	ClientPluginAuthLenencClientData = 1 << 21
+	ClientSessionTrack               = 1 << 23
+	ClientDeprecateEOF               = 1 << 24
+)
+
+const (
+	headerLen = 4
+
+	// Fixed part of an SSLRequest and of a HandshakeResponse41
+	fixedLen = 32
+
+	// Largest handshake packet accepted, well above what clients send
+	maxHandshakeLen = 1 << 16
+)
+
+// Handshake is the HandshakeV10 packet the server greets clients with.
+type Handshake struct {
+	ServerVersion string
+	ConnectionID  uint32
+
+	// Scramble the client authentication is computed on, 20 bytes for the
+	// usual plugins
+	AuthData []byte
+
+	Capabilities uint32
+	Charset      byte
+	Status       uint16
+
+	// Authentication plugin of the scramble
+	AuthPlugin string
+}
+
+// Packet returns the handshake as the first packet of a connection.
+func (h *Handshake) Packet() []byte {
+	p := []byte{10}
+	p = append(p, h.ServerVersion...)
+	p = append(p, 0)
+	p = appendU32(p, h.ConnectionID)
+
+	// Scramble split in 8 bytes and the rest, at least 12
+	part1 := make([]byte, 8)
+	copy(part1, h.AuthData)
+	var part2 []byte
+	if len(h.AuthData) > 8 {
+		part2 = append(part2, h.AuthData[8:]...)
+	}
+	for len(part2) < 12 {
+		part2 = append(part2, 0)
+	}
+
+	p = append(p, part1...)
+	p = append(p, 0)
+	p = appendU16(p, uint16(h.Capabilities))
+	p = append(p, h.Charset)
+	p = appendU16(p, h.Status)
+	p = appendU16(p, uint16(h.Capabilities>>16))
+	if h.Capabilities&ClientPluginAuth != 0 {
+		p = append(p, byte(len(part1)+len(part2)+1))
+	} else {
+		p = append(p, 0)
+	}
+	p = append(p, make([]byte, 10)...)
+	if h.Capabilities&ClientSecureConnection != 0 {
+		p = append(p, part2...)
+		p = append(p, 0)
+	}
+	if h.Capabilities&ClientPluginAuth != 0 {
+		p = append(p, h.AuthPlugin...)
+		p = append(p, 0)
+	}
+	return packet(0, p)
+}
+
+// ParseHandshake parses the HandshakeV10 payload sent by a server.
+func ParseHandshake(b []byte) (*Handshake, error) {
+	r := &wire.Reader{B: b}
+	if r.U8() != 10 {
+		return nil, errMalformed
+	}
+	h := &Handshake{
+		ServerVersion: cstring(r),
+		ConnectionID:  r.U32LE(),
+	}
+	h.AuthData = append(h.AuthData, r.Bytes(8)...)
+	r.U8()
+	h.Capabilities = uint32(r.U16LE())
+	if !r.Empty() {
+		h.Charset = r.U8()
+		h.Status = r.U16LE()
+		h.Capabilities |= uint32(r.U16LE()) << 16
+		dataLen := int(r.U8())
+		r.Bytes(10)
+		if h.Capabilities&ClientSecureConnection != 0 {
+			n := dataLen - 9
+			if n < 12 {
+				n = 12
+			}
+			h.AuthData = append(h.AuthData, r.Bytes(n)...)
+			r.U8()
+		}
+		if h.Capabilities&ClientPluginAuth != 0 {
+			h.AuthPlugin = cstring(r)
+		}
+	}
+	if r.Bad {
+		return nil, errMalformed
+	}
+	return h, nil
+}
+
+// Response is the first packet sent by a client, either an SSLRequest or a
+// HandshakeResponse41.
+type Response struct {
+	// Set for an SSLRequest, which only carries the fixed part
+	SSLRequest bool
+
+	// Length of the packet, header included, and its sequence number
+	Len int
+	Seq byte
+
+	Capabilities uint32
+	MaxPacket    uint32
+	Charset      byte
+
+	Username     string
+	AuthResponse []byte
+	Database     string
+	AuthPlugin   string
+
+	// Connection attributes, such as _client_name or program_name
+	Attrs map[string]string
+
+	attrs []byte // raw connection attributes
+	rest  []byte // trailing fields, such as the zstd compression level
+}
+
+// Packet returns the response as a packet with the given sequence number.
+func (r *Response) Packet(seq byte) []byte {
+	p := appendU32(nil, r.Capabilities)
+	p = appendU32(p, r.MaxPacket)
+	p = append(p, r.Charset)
+	p = append(p, make([]byte, 23)...)
+	if r.SSLRequest {
+		return packet(seq, p)
+	}
+	p = append(p, r.Username...)
+	p = append(p, 0)
+	switch {
+	case r.Capabilities&ClientPluginAuthLenencClientData != 0:
+		p = appendLenenc(p, uint64(len(r.AuthResponse)))
+		p = append(p, r.AuthResponse...)
+	case r.Capabilities&ClientSecureConnection != 0:
+		p = append(p, byte(len(r.AuthResponse)))
+		p = append(p, r.AuthResponse...)
+	default:
+		p = append(p, r.AuthResponse...)
+		p = append(p, 0)
+	}
+	if r.Capabilities&ClientConnectWithDB != 0 {
+		p = append(p, r.Database...)
+		p = append(p, 0)
+	}
+	if r.Capabilities&ClientPluginAuth != 0 {
+		p = append(p, r.AuthPlugin...)
+		p = append(p, 0)
+	}
+	if r.Capabilities&ClientConnectAttrs != 0 {
+		p = appendLenenc(p, uint64(len(r.attrs)))
+		p = append(p, r.attrs...)
+	}
+	p = append(p, r.rest...)
+	return packet(seq, p)
+}
+
+// Parse reads the client packet at the start of b.  ErrIncomplete is returned
+// while more bytes are needed.
+func Parse(b []byte) (*Response, error) {
+	m, _, err := parse(b)
+	return m, err
+}
+
+func parse(b []byte) (*Response, int, error) {
+	if len(b) < headerLen {
+		return nil, headerLen, ErrIncomplete
+	}
+	l := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+	if l < fixedLen || l > maxHandshakeLen {
+		return nil, 0, errMalformed
+	}
+	if len(b) < headerLen+fixedLen {
+		return nil, headerLen + fixedLen, ErrIncomplete
+	}
+
+	r := &wire.Reader{B: b[headerLen : headerLen+fixedLen]}
+	m := &Response{Len: headerLen + l, Seq: b[3]}
+	m.Capabilities = r.U32LE()
+	m.MaxPacket = r.U32LE()
+	m.Charset = r.U8()
+	if m.Capabilities&ClientProtocol41 == 0 || !allZero(r.Bytes(23)) {
+		return nil, 0, errMalformed
+	}
+	if l == fixedLen {
+		if m.Capabilities&ClientSSL == 0 {
+			return nil, 0, errMalformed
+		}
+		m.SSLRequest = true
+		return m, 0, nil
+	}
+	if len(b) < m.Len {
+		return nil, m.Len, ErrIncomplete
+	}
+
+	r = &wire.Reader{B: b[headerLen+fixedLen : m.Len]}
+	m.Username = cstring(r)
+	switch {
+	case m.Capabilities&ClientPluginAuthLenencClientData != 0:
+		m.AuthResponse = clone(r.Bytes(int(lenenc(r))))
+	case m.Capabilities&ClientSecureConnection != 0:
+		m.AuthResponse = clone(r.Bytes(int(r.U8())))
+	default:
+		m.AuthResponse = []byte(cstring(r))
+	}
+	if m.Capabilities&ClientConnectWithDB != 0 && !r.Empty() {
+		m.Database = cstring(r)
+	}
+	if m.Capabilities&ClientPluginAuth != 0 && !r.Empty() {
+		m.AuthPlugin = cstring(r)
+	}
+	if m.Capabilities&ClientConnectAttrs != 0 && !r.Empty() {
+		m.attrs = clone(r.Bytes(int(lenenc(r))))
+		m.Attrs = make(map[string]string)
+		ar := &wire.Reader{B: m.attrs}
+		for !ar.Empty() {
+			k := ar.Bytes(int(lenenc(ar)))
+			v := ar.Bytes(int(lenenc(ar)))
+			m.Attrs[string(k)] = string(v)
+		}
+		if ar.Bad {
+			return nil, 0, errMalformed
+		}
+	}
+	if r.Bad {
+		return nil, 0, errMalformed
+	}
+	m.rest = clone(r.B)
+	return m, 0, nil
+}
+
+// Matching returns a probe matching the client packets for which the
+// function returns true.  The probe only makes sense on a teaser where the
+// server handshake has already been sent.
+func Matching(match func(m *Response) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		m, need, err := parse(b)
+		return err == nil && match(m), need, err
+	})
+}
+
+// Probe matches any SSLRequest or HandshakeResponse41.
+var Probe = Matching(func(*Response) bool { return true })
+
+// Read waits for the client packet on the teaser and parses it.  No input is
+// consumed.
+func Read(s *tease.Server) (*Response, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
+
+// Prefix a payload with the packet header.
+func packet(seq byte, payload []byte) []byte {
+	l := len(payload)
+	return append([]byte{byte(l), byte(l >> 8), byte(l >> 16), seq}, payload...)
+}
+
+func appendLenenc(b []byte, v uint64) []byte {
+	switch {
+	case v < 251:
+		return append(b, byte(v))
+	case v < 1<<16:
+		return append(b, 0xfc, byte(v), byte(v>>8))
+	case v < 1<<24:
+		return append(b, 0xfd, byte(v), byte(v>>8), byte(v>>16))
+	}
+	return append(appendU32(append(b, 0xfe), uint32(v)), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+func appendU16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// Copy out of the teaser buffer
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+func allZero(b []byte) bool {
+	return len(bytes.Trim(b, "\x00")) == 0
+}
+
+// Length encoded integer
+func lenenc(r *wire.Reader) uint64 {
+	switch c := r.U8(); c {
+	case 0xfc:
+		return uint64(r.U16LE())
+	case 0xfd:
+		return uint64(r.U24LE())
+	case 0xfe:
+		return r.U64LE()
+	case 0xfb, 0xff:
+		r.Fail()
+		return 0
+	default:
+		return uint64(c)
+	}
+}
+
+// NUL terminated string
+func cstring(r *wire.Reader) string {
+	i := bytes.IndexByte(r.B, 0)
+	if i < 0 {
+		r.Fail()
+		return ""
+	}
+	s := string(r.B[:i])
+	r.B = r.B[i+1:]
+	return s
+}
@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/pschou/go-tease/internal/wire"
+)
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	hs := defaultHandshake
+	hs.ConnectionID = 42
+	hs.AuthData = []byte("0123456789abcdefghij")
+	p := hs.Packet()
+	if int(p[0])|int(p[1])<<8|int(p[2])<<16 != len(p)-headerLen || p[3] != 0 {
+		t.Fatalf("bad packet header %x", p[:headerLen])
+	}
+	got, err := ParseHandshake(p[headerLen:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, hs) {
+		t.Errorf("parsed %+v, want %+v", *got, hs)
+	}
+}
+
+// Connection attributes in their wire form.
+func attrs(kv ...string) []byte {
+	var b []byte
+	for _, s := range kv {
+		b = appendLenenc(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	const base = ClientProtocol41 | ClientSecureConnection | ClientPluginAuth
+	tests := []struct {
+		name string
+		r    Response
+	}{
+		{"ssl request", Response{
+			SSLRequest: true, Capabilities: base | ClientSSL, MaxPacket: 1 << 24, Charset: 45,
+		}},
+		{"secure connection", Response{
+			Capabilities: base | ClientConnectWithDB, MaxPacket: 1 << 24, Charset: 45,
+			Username: "app", AuthResponse: bytes.Repeat([]byte{7}, 20),
+			Database: "billing", AuthPlugin: "mysql_native_password",
+		}},
+		{"lenenc auth and attributes", Response{
+			Capabilities: base | ClientPluginAuthLenencClientData | ClientConnectAttrs,
+			Username:     "root", AuthResponse: bytes.Repeat([]byte{1}, 300),
+			AuthPlugin: "caching_sha2_password",
+			Attrs:      map[string]string{"_client_name": "libmysql", "program_name": "mysql"},
+			attrs:      attrs("_client_name", "libmysql", "program_name", "mysql"),
+			rest:       []byte{3},
+		}},
+		{"old auth", Response{
+			Capabilities: ClientProtocol41, Username: "u", AuthResponse: []byte("secret"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.r.Packet(1)
+			got, err := Parse(append(p, "more"...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.r.Len, tt.r.Seq = len(p), 1
+			if !reflect.DeepEqual(*got, tt.r) {
+				t.Errorf("parsed %+v\nwant %+v", *got, tt.r)
+			}
+			if !bytes.Equal(got.Packet(1), p) {
+				t.Errorf("packet not reproduced")
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	fixed := func(caps uint32, filler byte) []byte {
+		p := appendU32(nil, caps)
+		p = appendU32(p, 1<<24)
+		p = append(p, 45)
+		p = append(p, bytes.Repeat([]byte{filler}, 23)...)
+		return p
+	}
+	ssl := packet(1, fixed(ClientProtocol41|ClientSSL, 0))
+	hello := (&Response{Capabilities: ClientProtocol41 | ClientSecureConnection, Username: "app"}).Packet(1)
+	tests := []struct {
+		name string
+		in   []byte
+		need int
+	}{
+		{"empty", nil, headerLen},
+		{"header only", ssl[:headerLen], headerLen + fixedLen},
+		{"partial body", hello[:len(hello)-1], len(hello)},
+		{"too short", packet(1, []byte("abc")), 0},
+		{"no protocol 41", packet(1, fixed(ClientSSL, 0)), 0},
+		{"ssl request without the flag", packet(1, fixed(ClientProtocol41, 0)), 0},
+		{"non zero filler", packet(1, fixed(ClientProtocol41|ClientSSL, 1)), 0},
+		{"http", []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"), 0},
+	}
+	for _, tt := range tests {
+		m, need, err := parse(tt.in)
+		if err == nil || need != tt.need || tt.need > 0 && err != ErrIncomplete {
+			t.Errorf("%s: got %+v, %d, %v, want need %d", tt.name, m, need, err, tt.need)
+		}
+	}
+}
+
+func TestLenenc(t *testing.T) {
+	for _, v := range []uint64{0, 250, 251, 1<<16 - 1, 1 << 16, 1<<24 - 1, 1 << 24, 1 << 40} {
+		r := &wire.Reader{B: appendLenenc(nil, v)}
+		if got := lenenc(r); got != v || r.Bad || !r.Empty() {
+			t.Errorf("lenenc %d read back as %d", v, got)
+		}
+	}
+	r := &wire.Reader{B: []byte{0xfb}}
+	if lenenc(r); !r.Bad {
+		t.Error("NULL marker accepted as a length")
+	}
+}
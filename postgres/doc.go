@@ -0,0 +1,10 @@
+/*
+Package postgres detects PostgreSQL clients on a teaser and takes care of the
+SSLRequest and GSSENCRequest negotiation, which otherwise stalls detection as
+the client waits for a one byte answer before sending anything else.
+
+After answering, teasing continues on what the client sends next: the TLS
+ClientHello after an accepted SSLRequest, or the StartupMessage, whose user
+and database parameters are exposed for routing.
+*/
+package postgres
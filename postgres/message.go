@@ -0,0 +1,172 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	// Returned by Parse while the message is not complete
+	ErrIncomplete = errors.New("postgres: incomplete message")
+	errMalformed  = errors.New("postgres: not a PostgreSQL startup packet")
+)
+
+// Request codes sent in place of a protocol version
+const (
+	codeCancel  = 80877102
+	codeSSL     = 80877103
+	codeGSSENC  = 80877104
+	maxStartLen = 10000
+)
+
+// Kind is the type of the first packet sent by a client.
+type Kind int
+
+const (
+	Startup Kind = iota
+	SSLRequest
+	GSSENCRequest
+	CancelRequest
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Startup:
+		return "StartupMessage"
+	case SSLRequest:
+		return "SSLRequest"
+	case GSSENCRequest:
+		return "GSSENCRequest"
+	case CancelRequest:
+		return "CancelRequest"
+	}
+	return "unknown"
+}
+
+// Message is a startup packet, which has no type byte unlike the later
+// messages of the protocol.
+type Message struct {
+	Kind Kind
+
+	// Length of the packet
+	Len int
+
+	// Protocol version of a StartupMessage
+	ProtocolMajor int
+	ProtocolMinor int
+
+	// Parameters of a StartupMessage, with the user and database split out
+	Params   map[string]string
+	User     string
+	Database string
+
+	// Backend process and secret key of a CancelRequest
+	ProcessID uint32
+	SecretKey []byte
+}
+
+// Parse reads the startup packet at the start of b.  ErrIncomplete is
+// returned while more bytes are needed.
+func Parse(b []byte) (*Message, error) {
+	m, _, err := parse(b)
+	return m, err
+}
+
+func parse(b []byte) (*Message, int, error) {
+	if len(b) < 8 {
+		// Startup packets are short, so the length starts with two zeros
+		if len(b) > 0 && b[0] != 0 || len(b) > 1 && b[1] != 0 {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+	l := int(binary.BigEndian.Uint32(b))
+	code := binary.BigEndian.Uint32(b[4:])
+	if l < 8 || l > maxStartLen {
+		return nil, 0, errMalformed
+	}
+
+	m := &Message{Len: l}
+	switch code {
+	case codeSSL, codeGSSENC:
+		if l != 8 {
+			return nil, 0, errMalformed
+		}
+		m.Kind = SSLRequest
+		if code == codeGSSENC {
+			m.Kind = GSSENCRequest
+		}
+		return m, 0, nil
+	case codeCancel:
+		// The secret key is four bytes before protocol 3.2, up to 256 after
+		if l < 16 || l > 12+256 {
+			return nil, 0, errMalformed
+		}
+	default:
+		if code>>16 != 3 {
+			return nil, 0, errMalformed
+		}
+	}
+	if len(b) < l {
+		return nil, l, ErrIncomplete
+	}
+
+	if code == codeCancel {
+		m.Kind = CancelRequest
+		m.ProcessID = binary.BigEndian.Uint32(b[8:])
+		m.SecretKey = append([]byte(nil), b[12:l]...)
+		return m, 0, nil
+	}
+
+	m.Kind = Startup
+	m.ProtocolMajor, m.ProtocolMinor = int(code>>16), int(code&0xffff)
+	m.Params = make(map[string]string)
+	params := b[8:l]
+	for {
+		i := bytes.IndexByte(params, 0)
+		if i < 0 {
+			return nil, 0, errMalformed
+		}
+		if i == 0 {
+			break
+		}
+		name := string(params[:i])
+		params = params[i+1:]
+		j := bytes.IndexByte(params, 0)
+		if j < 0 {
+			return nil, 0, errMalformed
+		}
+		m.Params[name] = string(params[:j])
+		params = params[j+1:]
+	}
+	m.User = m.Params["user"]
+	m.Database = m.Params["database"]
+	if m.Database == "" {
+		m.Database = m.User
+	}
+	return m, 0, nil
+}
+
+// Matching returns a probe matching the startup packets for which the
+// function returns true.
+func Matching(match func(m *Message) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		m, need, err := parse(b)
+		return err == nil && match(m), need, err
+	})
+}
+
+// Probe matches any PostgreSQL client.
+var Probe = Matching(func(*Message) bool { return true })
+
+// Read waits for the startup packet on the teaser and parses it.  No input is
+// consumed.
+func Read(s *tease.Server) (*Message, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
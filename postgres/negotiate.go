@@ -0,0 +1,180 @@
+package postgres
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var errBackendAnswer = errors.New("postgres: backend answered the negotiation differently")
+
+// Session is the outcome of the encryption negotiation with a client.
+type Session struct {
+	// Encryption requests answered, in order, along with the answers given
+	Requests []*Message
+	Answers  []byte
+
+	// Set when an SSLRequest was accepted, the client is now starting TLS
+	TLS bool
+
+	// Set when a GSSENCRequest was accepted
+	GSSENC bool
+
+	// First packet after the negotiation when it is not encrypted, either a
+	// StartupMessage or a CancelRequest
+	Message *Message
+
+	// Teaser the negotiation took place on, still in tease mode with its
+	// input committed up to the end of the negotiation
+	Conn *tease.Server
+}
+
+// Negotiate answers the SSLRequest and GSSENCRequest packets of a client on
+// the teaser.  Each request is consumed and answered with a write through,
+// so that teasing continues on what follows: a TLS ClientHello or a
+// StartupMessage can be inspected, and Replay rewinds to it, as usual.
+//
+// An SSLRequest is answered 'S' when acceptSSL is set, after which the client
+// speaks TLS on Session.Conn.  A GSSENCRequest is answered 'G' when acceptGSS
+// is set.  Refused requests are answered 'N' and the client carries on with
+// another request or its StartupMessage.
+func Negotiate(s *tease.Server, acceptSSL, acceptGSS bool) (*Session, error) {
+	sess := &Session{Conn: s}
+	seen := make(map[Kind]bool)
+	for {
+		m, err := Read(s)
+		if err != nil {
+			return nil, err
+		}
+
+		var answer byte = 'N'
+		switch m.Kind {
+		case SSLRequest:
+			if acceptSSL {
+				answer = 'S'
+			}
+		case GSSENCRequest:
+			if acceptGSS {
+				answer = 'G'
+			}
+		default:
+			sess.Message = m
+			return sess, nil
+		}
+
+		// The server takes each request once, a client asking again is broken
+		if seen[m.Kind] {
+			return nil, errMalformed
+		}
+		seen[m.Kind] = true
+
+		if err := answerRequest(s, m, answer); err != nil {
+			return nil, err
+		}
+		sess.Requests = append(sess.Requests, m)
+		sess.Answers = append(sess.Answers, answer)
+		switch answer {
+		case 'S':
+			sess.TLS = true
+			return sess, nil
+		case 'G':
+			sess.GSSENC = true
+			return sess, nil
+		}
+	}
+}
+
+// Consume the request and commit it with the one byte answer.
+func answerRequest(s *tease.Server, m *Message, answer byte) error {
+	s.Replay()
+	if _, err := io.ReadFull(s, make([]byte, m.Len)); err != nil {
+		return err
+	}
+	_, err := s.WriteThrough([]byte{answer})
+	return err
+}
+
+// Prepare replays the accepted request, if any, to a backend, checking it is
+// accepted there too, so that the teased connection can then be spliced to
+// it.  Refused requests are not replayed: the client carried on in the clear,
+// so the backend directly gets what follows them.
+func (sess *Session) Prepare(backend net.Conn) error {
+	answer := []byte{0}
+	for i, m := range sess.Requests {
+		if sess.Answers[i] == 'N' {
+			continue
+		}
+		req := make([]byte, 8)
+		req[3] = 8
+		code := uint32(codeSSL)
+		if m.Kind == GSSENCRequest {
+			code = codeGSSENC
+		}
+		req[4], req[5], req[6], req[7] = byte(code>>24), byte(code>>16), byte(code>>8), byte(code)
+		if _, err := backend.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(backend, answer); err != nil {
+			return err
+		}
+		if answer[0] != sess.Answers[i] {
+			return errBackendAnswer
+		}
+	}
+	return nil
+}
+
+// Router is a tease.Handler which negotiates with PostgreSQL clients, then
+// forwards them to the backend picked by Route.  The backend goes through the
+// same negotiation before the connection is spliced.
+type Router struct {
+	// Accept SSLRequests.  The TLS session is passed through to the backend,
+	// which must accept SSL too.
+	AcceptSSL bool
+
+	// Pick the backend address for a session, or return an empty address to
+	// drop it.  For a TLS session the ClientHello can be inspected on
+	// sess.Conn, for instance with tls.Read, otherwise sess.Message holds the
+	// StartupMessage with the user and database.
+	Route func(sess *Session) string
+
+	// Time allowed to reach the backend once the startup packet is in hand.
+	// The client has already been answered and sits waiting for
+	// authentication meanwhile, so keep it short.  Zero leaves it to the
+	// operating system.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the backends.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// PROXY protocol version of the header sent to the backends ahead of the
+	// replayed negotiation, zero for none.
+	ProxyProtocol int
+}
+
+// ServeTease negotiates, picks the backend and splices the connection to it.
+func (r *Router) ServeTease(s *tease.Server) {
+	sess, err := Negotiate(s, r.AcceptSSL, false)
+	if err != nil {
+		s.Abort()
+		return
+	}
+	addr := r.Route(sess)
+	if addr == "" {
+		s.Abort()
+		return
+	}
+
+	f := &tease.Forwarder{
+		Addr:          addr,
+		DialTimeout:   r.DialTimeout,
+		Dial:          r.Dial,
+		ProxyProtocol: r.ProxyProtocol,
+		Protocol:      "postgresql",
+		Prepare:       sess.Prepare,
+	}
+	f.ServeTease(s)
+}
@@ -0,0 +1,81 @@
+package tease
+
+// Result is the verdict of a Probe on the bytes buffered so far.
+type Result int
+
+const (
+	// More bytes are needed before a verdict can be given.
+	NeedMore Result = iota
+
+	// The buffered bytes belong to the protocol.
+	Match
+
+	// The buffered bytes do not belong to the protocol.
+	NoMatch
+)
+
+func (r Result) String() string {
+	switch r {
+	case NeedMore:
+		return "NeedMore"
+	case Match:
+		return "Match"
+	case NoMatch:
+		return "NoMatch"
+	}
+	return "Result(?)"
+}
+
+// A Probe looks at the prefix of a teased connection and decides whether it
+// belongs to a protocol.  When the verdict is NeedMore, need is the minimum
+// total number of bytes to be buffered before the probe is worth calling
+// again; any value not larger than len(b) means just wait for the next read.
+//
+// A probe must not ask for bytes beyond the point where it could tell the
+// input is not its own.  Detect holds the lower priority probes back until
+// the need is met, so a client sending a short message and waiting for the
+// answer, such as a SOCKS greeting, would otherwise never be classified.  A
+// probe checking a fixed prefix byte by byte leaves need at zero.
+//
+// The slice passed to Probe is the teaser's own buffer and must not be
+// modified or retained.
+type Probe interface {
+	Probe(b []byte) (r Result, need int)
+}
+
+// The ProbeFunc type is an adapter to allow the use of ordinary functions as
+// probes.
+type ProbeFunc func(b []byte) (Result, int)
+
+// Probe calls f(b).
+func (f ProbeFunc) Probe(b []byte) (Result, int) {
+	return f(b)
+}
+
+// Probe which matches anything, including an empty connection.
+var anyProbe = ProbeFunc(func([]byte) (Result, int) { return Match, 0 })
+
+// Probe which matches as soon as the client sent anything.
+var spokeProbe = ProbeFunc(func(b []byte) (Result, int) {
+	if len(b) > 0 {
+		return Match, 0
+	}
+	return NeedMore, 1
+})
+
+// ParseProbe returns a probe built on the parser of a protocol package, which
+// reports whether the start of b matches.  While the parser returns the
+// incomplete error the probe asks for the need it reports; any other error
+// means NoMatch.
+func ParseProbe(incomplete error, parse func(b []byte) (match bool, need int, err error)) Probe {
+	return ProbeFunc(func(b []byte) (Result, int) {
+		ok, need, err := parse(b)
+		switch {
+		case err == incomplete:
+			return NeedMore, need
+		case err == nil && ok:
+			return Match, 0
+		}
+		return NoMatch, 0
+	})
+}
@@ -0,0 +1,442 @@
+package tease
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("tease: malformed PROXY protocol header")
+)
+
+const (
+	proxyV1MaxLen    = 107
+	proxyV2HeaderLen = 16
+)
+
+// Types of the TLVs which can follow a PROXY protocol v2 header.
+const (
+	PP2TypeALPN          byte = 0x01
+	PP2TypeAuthority     byte = 0x02
+	PP2TypeCRC32C        byte = 0x03
+	PP2TypeNoop          byte = 0x04
+	PP2TypeUniqueID      byte = 0x05
+	PP2TypeSSL           byte = 0x20
+	PP2SubTypeSSLVersion byte = 0x21
+	PP2SubTypeSSLCN      byte = 0x22
+	PP2SubTypeSSLCipher  byte = 0x23
+	PP2SubTypeSSLSigAlg  byte = 0x24
+	PP2SubTypeSSLKeyAlg  byte = 0x25
+	PP2TypeNetNS         byte = 0x30
+
+	// Custom TLV carrying the name of the protocol detected by the teaser
+	PP2TypeProtocol byte = 0xE0
+)
+
+// ProxyTLV is a type-length-value field of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxySSL holds the PP2_TYPE_SSL information about the client TLS session.
+type ProxySSL struct {
+	// PP2_CLIENT_* bit field
+	Client byte
+
+	// Zero when the client presented a certificate which was verified
+	Verify uint32
+
+	Version string
+	CN      string
+	Cipher  string
+	SigAlg  string
+	KeyAlg  string
+}
+
+// ProxyHeader holds the original connection details sent by a load balancer
+// in a PROXY protocol v1 or v2 header.
+type ProxyHeader struct {
+	// Protocol version, 1 or 2
+	Version int
+
+	// Set for a v2 LOCAL command, or a v1 UNKNOWN protocol, in which case the
+	// addresses are those of the connection itself.
+	Local bool
+
+	// Original addresses of the connection, nil when unknown
+	Source      net.Addr
+	Destination net.Addr
+
+	// All the TLVs of a v2 header, in order
+	TLVs []ProxyTLV
+
+	// Decoded values of the well known TLVs
+	ALPN      []byte
+	Authority string
+	UniqueID  []byte
+	SSL       *ProxySSL
+}
+
+// Create a header for forwarding a connection, carrying its remote address
+// as the source and its local address as the destination.
+func NewProxyHeader(version int, conn net.Conn) *ProxyHeader {
+	return &ProxyHeader{
+		Version:     version,
+		Source:      conn.RemoteAddr(),
+		Destination: conn.LocalAddr(),
+	}
+}
+
+// Format encodes the header in its version, 1 or 2.  A v1 header carries only
+// TCP addresses and falls back to "UNKNOWN" for anything else.  In a v2
+// header the ALPN, Authority and UniqueID fields are sent when set, followed
+// by the TLVs of other types.
+func (h *ProxyHeader) Format() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.formatV1(), nil
+	case 2:
+		return h.formatV2()
+	}
+	return nil, errors.New("tease: unknown PROXY protocol version " + strconv.Itoa(h.Version))
+}
+
+func (h *ProxyHeader) formatV1() []byte {
+	src, ok1 := h.Source.(*net.TCPAddr)
+	dst, ok2 := h.Destination.(*net.TCPAddr)
+	if h.Local || !ok1 || !ok2 {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto := "TCP4"
+	if src.IP.To4() == nil || dst.IP.To4() == nil {
+		proto = "TCP6"
+	}
+	return []byte("PROXY " + proto + " " + v1IP(src.IP, proto) + " " + v1IP(dst.IP, proto) + " " +
+		strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + "\r\n")
+}
+
+// Addresses in a TCP6 line must all be written in IPv6 form.
+func v1IP(ip net.IP, proto string) string {
+	if proto == "TCP6" && ip.To4() != nil {
+		return "::ffff:" + ip.String()
+	}
+	return ip.String()
+}
+
+func (h *ProxyHeader) formatV2() ([]byte, error) {
+	b := append([]byte(nil), proxyV2Sig...)
+	if h.Local {
+		b = append(b, 0x20, 0x00, 0, 0)
+	} else {
+		b = append(b, 0x21, 0x00, 0, 0)
+		// The append may move b, so index it only once it is done
+		fam := appendV2Addrs(&b, h.Source, h.Destination)
+		b[13] = fam
+	}
+
+	tlv := func(typ byte, v []byte) {
+		b = append(b, typ, byte(len(v)>>8), byte(len(v)))
+		b = append(b, v...)
+	}
+	if len(h.ALPN) > 0 {
+		tlv(PP2TypeALPN, h.ALPN)
+	}
+	if h.Authority != "" {
+		tlv(PP2TypeAuthority, []byte(h.Authority))
+	}
+	if len(h.UniqueID) > 0 {
+		tlv(PP2TypeUniqueID, h.UniqueID)
+	}
+	for _, t := range h.TLVs {
+		switch t.Type {
+		case PP2TypeALPN, PP2TypeAuthority, PP2TypeUniqueID:
+			continue
+		}
+		tlv(t.Type, t.Value)
+	}
+
+	l := len(b) - proxyV2HeaderLen
+	if l > 0xffff {
+		return nil, errProxyHeader
+	}
+	binary.BigEndian.PutUint16(b[14:16], uint16(l))
+	return b, nil
+}
+
+// Append the address block and return the family and transport byte, zero
+// when the addresses cannot be represented.
+func appendV2Addrs(b *[]byte, src, dst net.Addr) byte {
+	ipPort := func(a net.Addr) (net.IP, int, byte) {
+		switch a := a.(type) {
+		case *net.TCPAddr:
+			return a.IP, a.Port, 0x1
+		case *net.UDPAddr:
+			return a.IP, a.Port, 0x2
+		}
+		return nil, 0, 0
+	}
+	port := func(p int) []byte { return []byte{byte(p >> 8), byte(p)} }
+
+	if su, ok := src.(*net.UnixAddr); ok {
+		du, ok := dst.(*net.UnixAddr)
+		if !ok {
+			return 0
+		}
+		var paths [216]byte
+		copy(paths[:108], su.Name)
+		copy(paths[108:], du.Name)
+		*b = append(*b, paths[:]...)
+		if su.Net == "unixgram" {
+			return 0x32
+		}
+		return 0x31
+	}
+
+	sip, sport, st := ipPort(src)
+	dip, dport, dt := ipPort(dst)
+	if sip == nil || dip == nil || st != dt {
+		return 0
+	}
+	if s4, d4 := sip.To4(), dip.To4(); s4 != nil && d4 != nil {
+		*b = append(*b, s4...)
+		*b = append(*b, d4...)
+		*b = append(*b, port(sport)...)
+		*b = append(*b, port(dport)...)
+		return 0x10 | st
+	}
+	*b = append(*b, sip.To16()...)
+	*b = append(*b, dip.To16()...)
+	*b = append(*b, port(sport)...)
+	*b = append(*b, port(dport)...)
+	return 0x20 | st
+}
+
+// Probe for a complete PROXY protocol header.
+var proxyProbe = ProbeFunc(func(b []byte) (Result, int) {
+	_, need, err := parseProxyHeader(b)
+	switch {
+	case err == nil:
+		return Match, 0
+	case need > 0:
+		return NeedMore, need
+	}
+	return NoMatch, 0
+})
+
+// Parse a header at the start of b, returning its length.  When more bytes
+// are needed, need is set along with the error.
+func parseProxyHeader(b []byte) (h *ProxyHeader, n int, err error) {
+	switch {
+	case len(b) >= len(proxyV2Sig) && bytes.HasPrefix(b, proxyV2Sig):
+		return parseProxyV2(b)
+	case len(b) >= len(proxyV1Prefix) && bytes.HasPrefix(b, proxyV1Prefix):
+		return parseProxyV1(b)
+	case bytes.HasPrefix(proxyV2Sig, b), bytes.HasPrefix(proxyV1Prefix, b):
+		// The next byte may rule out both signatures
+		return nil, len(b) + 1, errProxyHeader
+	}
+	return nil, 0, errProxyHeader
+}
+
+// Human readable header, such as "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func parseProxyV1(b []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= proxyV1MaxLen {
+			return nil, 0, errProxyHeader
+		}
+		return nil, len(b) + 1, errProxyHeader
+	}
+	if end+2 > proxyV1MaxLen {
+		return nil, 0, errProxyHeader
+	}
+
+	h := &ProxyHeader{Version: 1}
+	f := strings.Split(string(b[len(proxyV1Prefix):end]), " ")
+	switch f[0] {
+	case "UNKNOWN":
+		h.Local = true
+		return h, end + 2, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, 0, errProxyHeader
+	}
+	if len(f) != 5 {
+		return nil, 0, errProxyHeader
+	}
+	src, dst := net.ParseIP(f[1]), net.ParseIP(f[2])
+	sport, err1 := strconv.ParseUint(f[3], 10, 16)
+	dport, err2 := strconv.ParseUint(f[4], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, 0, errProxyHeader
+	}
+	h.Source = &net.TCPAddr{IP: src, Port: int(sport)}
+	h.Destination = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return h, end + 2, nil
+}
+
+// Binary header, with the addresses and TLVs following the fixed part
+func parseProxyV2(b []byte) (*ProxyHeader, int, error) {
+	if len(b) < proxyV2HeaderLen {
+		return nil, proxyV2HeaderLen, errProxyHeader
+	}
+	n := proxyV2HeaderLen + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < n {
+		return nil, n, errProxyHeader
+	}
+	if b[12]>>4 != 2 {
+		return nil, 0, errProxyHeader
+	}
+
+	h := &ProxyHeader{Version: 2}
+	switch b[12] & 0x0f {
+	case 0x0:
+		h.Local = true
+	case 0x1:
+	default:
+		return nil, 0, errProxyHeader
+	}
+
+	payload := b[proxyV2HeaderLen:n]
+	var l int
+	family, transport := b[13]>>4, b[13]&0x0f
+	switch family {
+	case 0x1: // AF_INET
+		l = 12
+		if len(payload) < l {
+			return nil, 0, errProxyHeader
+		}
+		h.Source = inetAddr(transport, payload[0:4], payload[8:10])
+		h.Destination = inetAddr(transport, payload[4:8], payload[10:12])
+	case 0x2: // AF_INET6
+		l = 36
+		if len(payload) < l {
+			return nil, 0, errProxyHeader
+		}
+		h.Source = inetAddr(transport, payload[0:16], payload[32:34])
+		h.Destination = inetAddr(transport, payload[16:32], payload[34:36])
+	case 0x3: // AF_UNIX
+		l = 216
+		if len(payload) < l {
+			return nil, 0, errProxyHeader
+		}
+		h.Source = unixAddr(transport, payload[0:108])
+		h.Destination = unixAddr(transport, payload[108:216])
+	}
+	if h.Local {
+		h.Source, h.Destination = nil, nil
+	}
+
+	tlvs, err := parseTLVs(payload[l:])
+	if err != nil {
+		return nil, 0, err
+	}
+	h.TLVs = tlvs
+	for _, t := range tlvs {
+		switch t.Type {
+		case PP2TypeALPN:
+			h.ALPN = t.Value
+		case PP2TypeAuthority:
+			h.Authority = string(t.Value)
+		case PP2TypeUniqueID:
+			h.UniqueID = t.Value
+		case PP2TypeSSL:
+			if h.SSL, err = parseProxySSL(t.Value); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return h, n, nil
+}
+
+func parseTLVs(b []byte) (tlvs []ProxyTLV, err error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errProxyHeader
+		}
+		l := 3 + int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < l {
+			return nil, errProxyHeader
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: b[0], Value: append([]byte(nil), b[3:l]...)})
+		b = b[l:]
+	}
+	return
+}
+
+func parseProxySSL(b []byte) (*ProxySSL, error) {
+	if len(b) < 5 {
+		return nil, errProxyHeader
+	}
+	ssl := &ProxySSL{
+		Client: b[0],
+		Verify: binary.BigEndian.Uint32(b[1:5]),
+	}
+	subs, err := parseTLVs(b[5:])
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range subs {
+		switch t.Type {
+		case PP2SubTypeSSLVersion:
+			ssl.Version = string(t.Value)
+		case PP2SubTypeSSLCN:
+			ssl.CN = string(t.Value)
+		case PP2SubTypeSSLCipher:
+			ssl.Cipher = string(t.Value)
+		case PP2SubTypeSSLSigAlg:
+			ssl.SigAlg = string(t.Value)
+		case PP2SubTypeSSLKeyAlg:
+			ssl.KeyAlg = string(t.Value)
+		}
+	}
+	return ssl, nil
+}
+
+func inetAddr(transport byte, ip, port []byte) net.Addr {
+	addrIP := append(net.IP(nil), ip...)
+	p := int(binary.BigEndian.Uint16(port))
+	if transport == 0x2 {
+		return &net.UDPAddr{IP: addrIP, Port: p}
+	}
+	return &net.TCPAddr{IP: addrIP, Port: p}
+}
+
+func unixAddr(transport byte, path []byte) net.Addr {
+	if i := bytes.IndexByte(path, 0); i >= 0 {
+		path = path[:i]
+	}
+	if transport == 0x2 {
+		return &net.UnixAddr{Name: string(path), Net: "unixgram"}
+	}
+	return &net.UnixAddr{Name: string(path), Net: "unix"}
+}
+
+// Report whether the address is within one of the networks.
+func trusted(addr net.Addr, nets []*net.IPNet) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
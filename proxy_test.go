@@ -0,0 +1,186 @@
+package tease
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseProxyV1(t *testing.T) {
+	tests := []struct {
+		in    string
+		src   string
+		dst   string
+		local bool
+		n     int
+		need  int
+		ok    bool
+	}{
+		{in: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET", src: "192.0.2.1:56324", dst: "192.0.2.2:443", n: 42, ok: true},
+		{in: "PROXY TCP6 2001:db8::1 2001:db8::2 4000 80\r\n", src: "[2001:db8::1]:4000", dst: "[2001:db8::2]:80", n: 44, ok: true},
+		{in: "PROXY UNKNOWN\r\n", local: true, n: 15, ok: true},
+		{in: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", local: true, n: 35, ok: true},
+		{in: "PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n"},
+		{in: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 65536\r\n"},
+		{in: "PROXY UDP4 192.0.2.1 192.0.2.2 1 2\r\n"},
+		{in: "PROXY TCP4 192.0.2.1", need: 21},
+		{in: "PRO", need: 4},
+		{in: "", need: 1},
+		{in: "GET / HTTP/1.1\r\n"},
+	}
+	for _, tt := range tests {
+		h, n, err := parseProxyHeader([]byte(tt.in))
+		if !tt.ok {
+			if err == nil || n != tt.need {
+				t.Errorf("%q: got %v, %d, %v, want need %d", tt.in, h, n, err, tt.need)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if h.Version != 1 || h.Local != tt.local || n != tt.n {
+			t.Errorf("%q: version %d, local %v, length %d", tt.in, h.Version, h.Local, n)
+		}
+		if !tt.local && (h.Source.String() != tt.src || h.Destination.String() != tt.dst) {
+			t.Errorf("%q: addresses %v, %v", tt.in, h.Source, h.Destination)
+		}
+	}
+}
+
+func TestFormatProxyV1(t *testing.T) {
+	tcp := func(s string) net.Addr {
+		a, _ := net.ResolveTCPAddr("tcp", s)
+		return a
+	}
+	tests := []struct {
+		h    ProxyHeader
+		want string
+	}{
+		{ProxyHeader{Source: tcp("192.0.2.1:56324"), Destination: tcp("192.0.2.2:443")},
+			"PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"},
+		{ProxyHeader{Source: tcp("192.0.2.1:1"), Destination: tcp("[2001:db8::2]:2")},
+			"PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 1 2\r\n"},
+		{ProxyHeader{Source: &net.UnixAddr{Name: "/a"}, Destination: &net.UnixAddr{Name: "/b"}},
+			"PROXY UNKNOWN\r\n"},
+		{ProxyHeader{Local: true, Source: tcp("192.0.2.1:1"), Destination: tcp("192.0.2.2:2")},
+			"PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		tt.h.Version = 1
+		b, err := tt.h.Format()
+		if err != nil || string(b) != tt.want {
+			t.Errorf("Format() = %q, %v, want %q", b, err, tt.want)
+		}
+	}
+}
+
+func TestProxyV2RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		h    ProxyHeader
+	}{
+		{"tcp4", ProxyHeader{
+			Source:      &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 56324},
+			Destination: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2).To4(), Port: 443},
+		}},
+		{"udp6", ProxyHeader{
+			Source:      &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53},
+			Destination: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5353},
+		}},
+		{"unix", ProxyHeader{
+			Source:      &net.UnixAddr{Name: "/run/a.sock", Net: "unix"},
+			Destination: &net.UnixAddr{Name: "/run/b.sock", Net: "unix"},
+		}},
+		{"local", ProxyHeader{Local: true}},
+		{"tlvs", ProxyHeader{
+			Source:      &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1},
+			Destination: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 2},
+			ALPN:        []byte("h2"),
+			Authority:   "example.com",
+			UniqueID:    []byte{1, 2, 3},
+			TLVs:        []ProxyTLV{{Type: PP2TypeProtocol, Value: []byte("http")}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.h.Version = 2
+			b, err := tt.h.Format()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(b, proxyV2Sig) {
+				t.Fatalf("no v2 signature in %x", b)
+			}
+			h, n, err := parseProxyHeader(append(b, "payload"...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(b) {
+				t.Errorf("parsed %d bytes of %d", n, len(b))
+			}
+			if h.Local != tt.h.Local ||
+				!reflect.DeepEqual(h.Source, tt.h.Source) ||
+				!reflect.DeepEqual(h.Destination, tt.h.Destination) {
+				t.Errorf("parsed %+v, want %+v", h, tt.h)
+			}
+			if string(h.ALPN) != string(tt.h.ALPN) || h.Authority != tt.h.Authority ||
+				string(h.UniqueID) != string(tt.h.UniqueID) {
+				t.Errorf("parsed TLVs %+v", h.TLVs)
+			}
+			for _, want := range tt.h.TLVs {
+				found := false
+				for _, got := range h.TLVs {
+					found = found || got.Type == want.Type && string(got.Value) == string(want.Value)
+				}
+				if !found {
+					t.Errorf("TLV %#x missing from %+v", want.Type, h.TLVs)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProxyV2(t *testing.T) {
+	hdr := func(ver byte, fam byte, payload ...byte) []byte {
+		b := append([]byte(nil), proxyV2Sig...)
+		b = append(b, ver, fam, 0, byte(len(payload)))
+		return append(b, payload...)
+	}
+	addrs := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+	ssl := []byte{PP2TypeSSL, 0, 15, 0x01, 0, 0, 0, 0, PP2SubTypeSSLVersion, 0, 7, 'T', 'L', 'S', 'v', '1', '.', '3'}
+
+	h, n, err := parseProxyHeader(hdr(0x21, 0x11, append(addrs, ssl...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != proxyV2HeaderLen+len(addrs)+len(ssl) {
+		t.Errorf("length %d", n)
+	}
+	if h.Source.String() != "192.0.2.1:56324" || h.Destination.String() != "192.0.2.2:443" {
+		t.Errorf("addresses %v, %v", h.Source, h.Destination)
+	}
+	if h.SSL == nil || h.SSL.Client != 0x01 || h.SSL.Version != "TLSv1.3" {
+		t.Errorf("SSL %+v", h.SSL)
+	}
+
+	bad := []struct {
+		name string
+		in   []byte
+		need int
+	}{
+		{"version 1", hdr(0x11, 0x11, addrs...), 0},
+		{"unknown command", hdr(0x22, 0x11, addrs...), 0},
+		{"short addresses", hdr(0x21, 0x11, addrs[:8]...), 0},
+		{"truncated tlv", hdr(0x21, 0x11, append(addrs, ssl[:5]...)...), 0},
+		{"partial", hdr(0x21, 0x11, addrs...)[:20], proxyV2HeaderLen + len(addrs)},
+		{"partial signature", proxyV2Sig[:5], 6},
+	}
+	for _, tt := range bad {
+		if _, need, err := parseProxyHeader(tt.in); err == nil || need != tt.need {
+			t.Errorf("%s: need %d, %v, want need %d", tt.name, need, err, tt.need)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+/*
+Package redis detects Redis clients in the teaser buffer, whether they speak
+RESP arrays or inline commands, and parses the commands they open with.
+
+Clients usually start with AUTH or HELLO, possibly pipelined with SELECT and
+CLIENT SETNAME in the same read, so the username, database and client name
+are exposed for routing tenants to their own instances.
+*/
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	// Returned by Parse while the first command is not complete
+	ErrIncomplete = errors.New("redis: incomplete command")
+	errMalformed  = errors.New("redis: not a Redis command")
+)
+
+const (
+	// Longest inline command, as accepted by the server
+	maxInlineLen = 64 * 1024
+
+	// Limits on the arrays of a RESP command
+	maxArgs   = 1024 * 1024
+	maxArgLen = 512 * 1024 * 1024
+)
+
+// Commands accepted on their own on an inline line, so that any text
+// protocol is not taken for Redis.
+var inlineCommands = map[string]bool{
+	"AUTH": true, "HELLO": true, "SELECT": true, "CLIENT": true,
+	"PING": true, "ECHO": true, "INFO": true, "QUIT": true, "COMMAND": true,
+	"GET": true, "SET": true, "DEL": true, "EXISTS": true, "KEYS": true,
+	"MONITOR": true, "SUBSCRIBE": true, "PSUBSCRIBE": true, "CONFIG": true,
+}
+
+// Command is a command sent by the client.
+type Command struct {
+	// Upper cased command name
+	Name string
+
+	// Arguments following the name
+	Args []string
+
+	// Set when sent as an inline command rather than a RESP array
+	Inline bool
+
+	// Length of the command in the input
+	Len int
+}
+
+// Client is the opening of a Redis client connection.
+type Client struct {
+	// Commands complete in the buffer, the first one followed by any
+	// pipelined along with it
+	Commands []*Command
+
+	// Username given to HELLO or AUTH, "default" when only a password was
+	// given, empty when the client did not authenticate up front
+	Username string
+
+	// RESP version asked with HELLO, 2 without it
+	Protocol int
+
+	// Database chosen with SELECT
+	DB int
+
+	// Connection name given to HELLO or CLIENT SETNAME
+	ClientName string
+}
+
+// Parse reads the commands at the start of b.  ErrIncomplete is returned
+// until the first one is complete.  Setup commands pipelined after it are
+// taken into account as long as they are complete.
+func Parse(b []byte) (*Client, error) {
+	c, _, err := parse(b)
+	return c, err
+}
+
+func parse(b []byte) (*Client, int, error) {
+	cmd, need, err := parseCommand(b)
+	if err != nil {
+		return nil, need, err
+	}
+	c := &Client{Protocol: 2}
+	for {
+		c.Commands = append(c.Commands, cmd)
+		b = b[cmd.Len:]
+		if cmd, _, err = parseCommand(b); err != nil {
+			break
+		}
+	}
+	c.setup()
+	return c, 0, nil
+}
+
+// Pick the connection settings out of the setup commands at the start.
+func (c *Client) setup() {
+	for _, cmd := range c.Commands {
+		switch cmd.Name {
+		case "AUTH":
+			switch len(cmd.Args) {
+			case 1:
+				c.Username = "default"
+			case 2:
+				c.Username = cmd.Args[0]
+			}
+		case "HELLO":
+			if len(cmd.Args) > 0 {
+				if v, err := strconv.Atoi(cmd.Args[0]); err == nil {
+					c.Protocol = v
+				}
+			}
+			for i := 1; i < len(cmd.Args); i++ {
+				switch strings.ToUpper(cmd.Args[i]) {
+				case "AUTH":
+					if i+2 < len(cmd.Args) {
+						c.Username = cmd.Args[i+1]
+					}
+					i += 2
+				case "SETNAME":
+					if i+1 < len(cmd.Args) {
+						c.ClientName = cmd.Args[i+1]
+					}
+					i++
+				}
+			}
+		case "SELECT":
+			if len(cmd.Args) == 1 {
+				c.DB, _ = strconv.Atoi(cmd.Args[0])
+			}
+		case "CLIENT":
+			if len(cmd.Args) == 2 && strings.ToUpper(cmd.Args[0]) == "SETNAME" {
+				c.ClientName = cmd.Args[1]
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Parse a single command, either a RESP array or an inline command.
+func parseCommand(b []byte) (*Command, int, error) {
+	if len(b) == 0 {
+		return nil, 1, ErrIncomplete
+	}
+	if b[0] == '*' {
+		return parseArray(b)
+	}
+	return parseInline(b)
+}
+
+// Parse a RESP array of bulk strings, such as "*1\r\n$4\r\nPING\r\n".
+func parseArray(b []byte) (*Command, int, error) {
+	n, off, err := parseLength(b, 0, '*', maxArgs)
+	if err != nil {
+		return nil, off, err
+	}
+	if n == 0 {
+		return nil, 0, errMalformed
+	}
+	// The count comes from the client, so only the arguments which can fit in
+	// the buffer are allocated for, at least 6 bytes each as in "$0\r\n\r\n"
+	size := n
+	if fit := (len(b) - off) / 6; size > fit {
+		size = fit
+	}
+	args := make([]string, 0, size)
+	for i := 0; i < n; i++ {
+		l, next, err := parseLength(b, off, '$', maxArgLen)
+		if err != nil {
+			return nil, next, err
+		}
+		end := next + l + 2
+		if len(b) < end {
+			return nil, end, ErrIncomplete
+		}
+		if b[end-2] != '\r' || b[end-1] != '\n' {
+			return nil, 0, errMalformed
+		}
+		args = append(args, string(b[next:next+l]))
+		off = end
+	}
+	return &Command{Name: strings.ToUpper(args[0]), Args: args[1:], Len: off}, 0, nil
+}
+
+// Parse the "<prefix><length>\r\n" header at off and return the length and
+// the offset past the header.  While the header is incomplete, the offset is
+// the number of bytes worth waiting for.
+func parseLength(b []byte, off int, prefix byte, max int) (int, int, error) {
+	if len(b) <= off {
+		return 0, off + 1, ErrIncomplete
+	}
+	if b[off] != prefix {
+		return 0, 0, errMalformed
+	}
+	i := bytes.IndexByte(b[off:], '\n')
+	if i < 0 {
+		if len(b)-off > 12 {
+			return 0, 0, errMalformed
+		}
+		return 0, len(b) + 1, ErrIncomplete
+	}
+	line := b[off+1 : off+i]
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		return 0, 0, errMalformed
+	}
+	n, err := strconv.Atoi(string(line[:len(line)-1]))
+	if err != nil || n < 0 || n > max {
+		return 0, 0, errMalformed
+	}
+	return n, off + i + 1, nil
+}
+
+// Parse an inline command, a line of space separated arguments.
+func parseInline(b []byte) (*Command, int, error) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		// Reject early when the first word cannot be a command
+		if j := bytes.IndexAny(b, " \t\r"); j >= 0 {
+			if !inlineCommands[strings.ToUpper(string(b[:j]))] {
+				return nil, 0, errMalformed
+			}
+		} else if !commandPrefix(string(b)) {
+			return nil, 0, errMalformed
+		}
+		if len(b) >= maxInlineLen || bytes.IndexFunc(b, isControl) >= 0 {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+	line := bytes.TrimSuffix(b[:i], []byte("\r"))
+	if bytes.IndexFunc(line, isControl) >= 0 {
+		return nil, 0, errMalformed
+	}
+	args, ok := splitArgs(string(line))
+	if !ok || len(args) == 0 || !inlineCommands[strings.ToUpper(args[0])] {
+		return nil, 0, errMalformed
+	}
+	// An HTTP request line is not a GET with two arguments
+	if strings.HasPrefix(args[len(args)-1], "HTTP/") {
+		return nil, 0, errMalformed
+	}
+	return &Command{Name: strings.ToUpper(args[0]), Args: args[1:], Inline: true, Len: i + 1}, 0, nil
+}
+
+// Split an inline command line like the server does, with double quoted
+// arguments taking backslash escapes and single quoted ones taken as is.
+func splitArgs(line string) ([]string, bool) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, true
+		}
+		var arg []byte
+		switch line[0] {
+		case '"':
+			i := 1
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					default:
+						arg = append(arg, line[i])
+					}
+					continue
+				}
+				arg = append(arg, line[i])
+			}
+			if i == len(line) {
+				return nil, false
+			}
+			line = line[i+1:]
+		case '\'':
+			i := strings.IndexByte(line[1:], '\'')
+			if i < 0 {
+				return nil, false
+			}
+			arg = []byte(line[1 : i+1])
+			line = line[i+2:]
+		default:
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			arg = []byte(line[:i])
+			line = line[i:]
+		}
+		// A closing quote must be followed by a space
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return nil, false
+		}
+		args = append(args, string(arg))
+	}
+}
+
+// Report whether the start of a word may still turn into an inline command.
+func commandPrefix(word string) bool {
+	word = strings.ToUpper(word)
+	for name := range inlineCommands {
+		if strings.HasPrefix(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isControl(r rune) bool {
+	return r < ' ' && r != '\t' && r != '\r' && r != '\n'
+}
+
+// Matching returns a probe matching the clients for which the function
+// returns true, once their first command is complete.  Only the setup
+// commands pipelined along with it are seen.
+func Matching(match func(c *Client) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		c, need, err := parse(b)
+		return err == nil && match(c), need, err
+	})
+}
+
+// Probe matches any Redis client.
+var Probe = Matching(func(*Client) bool { return true })
+
+// User returns a probe matching the clients authenticating up front as any
+// of the given usernames.
+func User(names ...string) tease.Probe {
+	return Matching(func(c *Client) bool {
+		for _, n := range names {
+			if c.Username == n {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Read waits for the first command on the teaser and parses it, along with
+// the commands pipelined after it.  No input is consumed.
+func Read(s *tease.Server) (*Client, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// RESP array of bulk strings
+func resp(args ...string) string {
+	s := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, a := range args {
+		s += "$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n"
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		commands []string
+		want     Client
+	}{
+		{
+			name:     "auth with password only",
+			in:       resp("auth", "secret"),
+			commands: []string{"AUTH"},
+			want:     Client{Username: "default", Protocol: 2},
+		},
+		{
+			name:     "hello",
+			in:       resp("HELLO", "3", "AUTH", "acme", "pw", "SETNAME", "worker"),
+			commands: []string{"HELLO"},
+			want:     Client{Username: "acme", Protocol: 3, ClientName: "worker"},
+		},
+		{
+			name: "pipelined setup",
+			in: resp("AUTH", "acme", "pw") + resp("SELECT", "2") + resp("CLIENT", "SETNAME", "job") +
+				resp("GET", "k") + resp("SELECT", "5") + "*1\r\n$4\r\nPI",
+			commands: []string{"AUTH", "SELECT", "CLIENT", "GET", "SELECT"},
+			want:     Client{Username: "acme", Protocol: 2, DB: 2, ClientName: "job"},
+		},
+		{
+			name:     "inline pipelined",
+			in:       "PING\r\nAUTH bob 'p w'\r\n",
+			commands: []string{"PING", "AUTH"},
+			want:     Client{Protocol: 2},
+		},
+		{
+			name:     "inline quoted",
+			in:       "AUTH bob \"p\\x41ss w\"\n",
+			commands: []string{"AUTH"},
+			want:     Client{Username: "bob", Protocol: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, cmd := range c.Commands {
+				names = append(names, cmd.Name)
+			}
+			if !reflect.DeepEqual(names, tt.commands) {
+				t.Errorf("commands %v, want %v", names, tt.commands)
+			}
+			c.Commands = nil
+			if !reflect.DeepEqual(*c, tt.want) {
+				t.Errorf("got %+v, want %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	cmd, _, err := parseCommand([]byte(`SET k "a b\n" 'c'` + "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Command{Name: "SET", Args: []string{"k", "a b\n", "c"}, Inline: true, Len: 19}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("got %+v, want %+v", cmd, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		need int
+	}{
+		{"empty", "", 1},
+		{"partial count", "*2", 3},
+		{"partial bulk", "*2\r\n$4\r\nAUTH\r\n$6\r\nsec", 26},
+		{"partial inline", "AUTH bob", 9},
+		{"empty array", "*0\r\n", 0},
+		{"too many arguments", "*1048577\r\n", 0},
+		{"count line too long", "*0000000000000", 0},
+		{"simple string", "*1\r\n+OK\r\n", 0},
+		{"bulk without crlf", "*1\r\n$4\r\nPINGxx", 0},
+		{"http", "GET / HTTP/1.1\r\n", 0},
+		{"unknown inline", "HEAD / HTTP/1.0\r\n", 0},
+		{"unknown partial inline", "EHLO ", 0},
+		{"inline too long", "SET k " + strings.Repeat("v", maxInlineLen), 0},
+		{"control character", "PING\x01", 0},
+		{"unbalanced quote", "AUTH \"bob\n", 0},
+	}
+	for _, tt := range tests {
+		c, need, err := parse([]byte(tt.in))
+		if err == nil || need != tt.need || tt.need > 0 && err != ErrIncomplete {
+			t.Errorf("%s: got %+v, %d, %v, want need %d", tt.name, c, need, err, tt.need)
+		}
+	}
+}
+
+// A large array count must not size an allocation before the arguments are
+// there, as Detect parses the buffer again on every read.
+func TestLargeArrayCount(t *testing.T) {
+	b := []byte("*1048576\r\n$4\r\nPING\r\n")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 10; i++ {
+		parse(b)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64*1024 {
+		t.Errorf("allocated %d bytes", n)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	b := []byte(resp("HELLO", "3", "AUTH", "acme", "pw"))
+	tests := []struct {
+		name  string
+		probe tease.Probe
+		want  tease.Result
+	}{
+		{"any", Probe, tease.Match},
+		{"user", User("globex", "acme"), tease.Match},
+		{"other user", User("globex"), tease.NoMatch},
+	}
+	for _, tt := range tests {
+		if r, _ := tt.probe.Probe(b); r != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, r, tt.want)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	r := &Router{Default: "127.0.0.1:1", DialTimeout: time.Second, ProxyProtocol: 1}
+	r.Add("acme", ln.Addr().String())
+	for user, want := range map[string]string{"acme": ln.Addr().String(), "globex": r.Default, "": r.Default} {
+		if got := r.Lookup(&Client{Username: user}); got != want {
+			t.Errorf("Lookup(%q) = %q, want %q", user, got, want)
+		}
+	}
+
+	a, b := net.Pipe()
+	defer b.Close()
+	hello := resp("AUTH", "acme", "pw")
+	go b.Write([]byte(hello))
+	go r.ServeTease(tease.NewServer(a))
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	if line, err := br.ReadString('\n'); err != nil || !strings.HasPrefix(line, "PROXY ") {
+		t.Fatalf("PROXY header %q, %v", line, err)
+	}
+	got := make([]byte, len(hello))
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != hello {
+		t.Errorf("replayed %q, %v", got, err)
+	}
+}
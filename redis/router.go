@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Router is a tease.Handler which sends Redis clients to the instance of
+// their tenant, picked from the username given to HELLO or AUTH.  The
+// commands are replayed to the instance, which does the authentication.
+type Router struct {
+	// Backend address used for clients whose username has no route, or which
+	// do not authenticate up front.  When empty those clients are dropped.
+	Default string
+
+	// Time allowed to reach the instance of the tenant.  Clients pipeline
+	// their first commands and wait on the replies, so the delay shows up as
+	// a slow AUTH or HELLO.  Zero leaves it to the operating system.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the backends.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// PROXY protocol version of the header sent to the backends ahead of the
+	// replayed commands, zero for none.
+	ProxyProtocol int
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// Add routes the clients authenticating as username to the backend address.
+func (r *Router) Add(username, addr string) {
+	r.mu.Lock()
+	if r.users == nil {
+		r.users = make(map[string]string)
+	}
+	r.users[username] = addr
+	r.mu.Unlock()
+}
+
+// Lookup returns the backend address for a client, or an empty string when
+// it is to be dropped.
+func (r *Router) Lookup(c *Client) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if addr, ok := r.users[c.Username]; ok && c.Username != "" {
+		return addr
+	}
+	return r.Default
+}
+
+// ServeTease reads the first commands and forwards the client to the backend
+// picked for it.
+func (r *Router) ServeTease(s *tease.Server) {
+	c, err := Read(s)
+	if err != nil {
+		s.Abort()
+		return
+	}
+	addr := r.Lookup(c)
+	if addr == "" {
+		s.Abort()
+		return
+	}
+	f := &tease.Forwarder{
+		Addr:          addr,
+		DialTimeout:   r.DialTimeout,
+		Dial:          r.Dial,
+		ProxyProtocol: r.ProxyProtocol,
+		Protocol:      "redis",
+	}
+	f.ServeTease(s)
+}
@@ -19,11 +19,21 @@ type Server struct {
 	// read past this the connection is terminated.
 	MaxBuffer int
 
+	// Networks of the load balancers trusted to send a PROXY protocol header,
+	// see ReadProxyHeader.
+	TrustedProxies []*net.IPNet
+
 	// input/output
 	rawInput  []byte // raw input buffer
 	inputCnt  int
 	rawOutput []byte // raw output buffer
+	commitIn  []byte // input consumed before a write through
+	commitOut []byte // output sent by write through
+	proxy     *ProxyHeader
 	mu        sync.Mutex
+
+	// read deadline set for detection, lifted once piped
+	detectDeadline bool
 }
 
 // Create a new teaser in server mode.  In server mode new incoming connections
@@ -41,6 +51,8 @@ func (c *Server) String() string {
 		c.isPiped, c.inputCnt, len(c.rawInput), len(c.rawOutput))
 }
 
+// Replay rewinds the input to the beginning, or to the point where the last
+// WriteThrough committed the input, and drops any buffered output.
 func (c *Server) Replay() error {
 	if c.isPiped {
 		// We are already connected, no reply allowed
@@ -77,6 +89,12 @@ func (c *Server) Pipe() (err error) {
 	// reset counters
 	c.inputCnt = 0
 
+	// the detection is over, the piped connection has no deadline
+	if c.detectDeadline {
+		c.conn.SetReadDeadline(time.Time{})
+		c.detectDeadline = false
+	}
+
 	// mark as connected
 	c.isPiped = true
 
@@ -111,6 +129,168 @@ func (c *Server) ReadByte() (byte, error) {
 	return byte(0), errors.New("EOF")
 }
 
+// ReadProxyHeader detects and strips a PROXY protocol v1 or v2 header from the
+// start of the input.  The header is only honored when the peer address is
+// within TrustedProxies, otherwise nil is returned and the input is left as
+// is.  Once stripped, the header is no longer part of the replayed input and
+// LocalAddr and RemoteAddr report the original addresses it carries.
+//
+// A connection from a trusted peer without a header is accepted as is.
+func (c *Server) ReadProxyHeader() (*ProxyHeader, error) {
+	if c.proxy != nil {
+		return c.proxy, nil
+	}
+	if !trusted(c.conn.RemoteAddr(), c.TrustedProxies) {
+		return nil, nil
+	}
+	if _, err := c.Detect(proxyProbe); err != nil {
+		if err == errNoMatch {
+			err = nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, n, err := parseProxyHeader(c.rawInput)
+	if err != nil {
+		return nil, err
+	}
+	c.rawInput = c.rawInput[n:]
+	c.inputCnt = 0
+	c.proxy = h
+	return h, nil
+}
+
+// ProxyHeader returns the PROXY protocol header read off the connection, if
+// any.
+func (c *Server) ProxyHeader() *ProxyHeader {
+	return c.proxy
+}
+
+// WriteThrough sends b on the connection right away while still in tease
+// mode, for protocols which need a handshake reply before the client reveals
+// enough to be classified.  The input read so far is committed along with the
+// output: later calls to Replay only rewind the input to this point, and
+// Buffered and Detect only see what follows it.
+//
+// Output buffered with Write cannot be sent ahead of a write through, so an
+// error is returned if there is any.
+func (c *Server) WriteThrough(b []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isPiped {
+		return c.conn.Write(b)
+	}
+	if len(c.rawOutput) > 0 {
+		return 0, errPending
+	}
+
+	// commit the input read so far
+	c.commitIn = append(c.commitIn, c.rawInput[:c.inputCnt]...)
+	c.rawInput = c.rawInput[c.inputCnt:]
+	c.inputCnt = 0
+
+	n, err = c.conn.Write(b)
+	c.commitOut = append(c.commitOut, b[:n]...)
+	return
+}
+
+// Committed returns the input consumed and the output sent on the connection
+// by the write throughs so far, for instance to replay the negotiation to a
+// backend before forwarding.
+func (c *Server) Committed() (input, output []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.commitIn, c.commitOut
+}
+
+// Buffered returns the bytes read off the connection while in tease mode,
+// starting from the beginning of the input stream, or from the point
+// committed by the last WriteThrough.  The returned slice is only valid until
+// the next read and must not be modified.
+func (c *Server) Buffered() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rawInput
+}
+
+// Detect feeds the buffered input to the probes, reading more off the
+// connection as long as the verdict is still open.  Probes are given priority
+// in the order they are passed in, so a probe only wins once all the probes
+// before it have answered NoMatch.  The index of the winning probe is
+// returned, or -1 with an error if none matched.
+//
+// Every byte is read off the connection only once and the read position is
+// left untouched, so a successful Detect can be followed directly by Pipe().
+func (c *Server) Detect(probes ...Probe) (int, error) {
+	need := make([]int, len(probes))
+	done := make([]bool, len(probes))
+	for {
+		buf := c.Buffered()
+		pending := false
+		for i, p := range probes {
+			if done[i] {
+				continue
+			}
+			if len(buf) < need[i] {
+				pending = true
+				break
+			}
+			r, n := p.Probe(buf)
+			switch r {
+			case Match:
+				return i, nil
+			case NoMatch:
+				done[i] = true
+				continue
+			}
+			if n <= len(buf) {
+				n = len(buf) + 1
+			}
+			need[i], pending = n, true
+			break
+		}
+		if !pending {
+			return -1, errNoMatch
+		}
+
+		if _, err := c.fill(); err != nil {
+			return -1, err
+		}
+	}
+}
+
+// Read whatever is available off the connection into the input buffer.
+func (c *Server) fill() (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isPiped {
+		return 0, errAlreadyPipe
+	}
+
+	// Mind limits
+	if len(c.rawInput) >= c.MaxBuffer {
+		err, c.err = errMaxBuffer, errMaxBuffer
+		c.conn.Close()
+		return
+	}
+
+	// Grow the buffer once up to the limit and read into the spare room
+	if cap(c.rawInput) < c.MaxBuffer {
+		buf := make([]byte, len(c.rawInput), c.MaxBuffer)
+		copy(buf, c.rawInput)
+		c.rawInput = buf
+	}
+	l := len(c.rawInput)
+	n, err = c.conn.Read(c.rawInput[l:cap(c.rawInput)])
+	c.rawInput = c.rawInput[:l+n]
+	c.err = err
+	return
+}
+
 func (c *Server) read(b []byte) (n int, err error) {
 	// If we are in an error state, give up
 	if err != nil {
@@ -123,18 +303,10 @@ func (c *Server) read(b []byte) (n int, err error) {
 	// If we are in a pipe mode, flush and then passthrough
 	if c.isPiped {
 		if len(c.rawInput) > 0 {
-			// read out buffer before going to raw connection
+			// read out buffer before going to raw connection, without blocking
+			// on the raw connection for more than what is already here
 			n = copy(b, c.rawInput)
-			if len(b) <= len(c.rawInput) {
-				c.rawInput = c.rawInput[n:]
-				return
-			}
-			c.rawInput = []byte{}
-			// read the rest from the raw connection
-			var read_n int
-			read_n, err = c.conn.Read(b[n:])
-			c.err = err
-			n += read_n
+			c.rawInput = c.rawInput[n:]
 			return
 		}
 		// short circuit when we don't need to do anything
@@ -215,6 +387,13 @@ func (c *Server) write(b []byte) (n int, err error) {
 
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
+//
+// Before Pipe() is called, Close only marks the teaser as closed.  Protocol
+// testers such as crypto/tls or net/http close the connection when the input
+// is not theirs, and the connection must survive that for Replay() to hand it
+// to the next tester.  A teaser is therefore only given to code which may
+// close it for good once piped, as the Mux and Handlers of this module do, and
+// a connection still in tease mode is dropped with Abort.
 func (c *Server) Close() error {
 	if c.isPiped {
 		// Only allow piped connections to be closed
@@ -225,13 +404,62 @@ func (c *Server) Close() error {
 	return nil
 }
 
-// LocalAddr returns the local network address.
+// CloseWrite shuts down the writing side of a piped connection, when the
+// underlying connection supports it.
+func (c *Server) CloseWrite() error {
+	if !c.isPiped {
+		return errClosed
+	}
+	if cw, ok := c.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.conn.Close()
+}
+
+// Abort closes the connection whether or not it has been piped, discarding
+// any buffered output.  When teasers are stacked, for instance a teaser
+// wrapping a crypto/tls connection over another teaser, the connection at the
+// bottom of the stack is closed, so the outer layers are not leaked while the
+// inner one is still in tease mode.
+func (c *Server) Abort() error {
+	c.mu.Lock()
+	c.err = errClosed
+	c.rawOutput = nil
+	c.mu.Unlock()
+
+	base := BaseConn(c.conn)
+	if base != c.conn {
+		c.conn.Close()
+	}
+	return base.Close()
+}
+
+// Unwrap returns the connection wrapped by the teaser.
+func (c *Server) Unwrap() net.Conn {
+	return c.conn
+}
+
+// NetConn returns the connection wrapped by the teaser, named after the
+// crypto/tls accessor so that both can be walked the same way.
+func (c *Server) NetConn() net.Conn {
+	return c.conn
+}
+
+// LocalAddr returns the local network address, or the original destination
+// address when a PROXY protocol header was read.
 func (c *Server) LocalAddr() net.Addr {
+	if c.proxy != nil && c.proxy.Destination != nil {
+		return c.proxy.Destination
+	}
 	return c.conn.LocalAddr()
 }
 
-// RemoteAddr returns the remote network address.
+// RemoteAddr returns the remote network address, or the original source
+// address when a PROXY protocol header was read.
 func (c *Server) RemoteAddr() net.Addr {
+	if c.proxy != nil && c.proxy.Source != nil {
+		return c.proxy.Source
+	}
 	return c.conn.RemoteAddr()
 }
 
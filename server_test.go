@@ -0,0 +1,236 @@
+package tease
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Teaser on one end of an in-memory connection, with the other end for the
+// test to play the client.  Reads time out rather than hang a broken test.
+func testPipe(t *testing.T) (*Server, net.Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	a.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return NewServer(a), b
+}
+
+// Send the chunks one write at a time, leaving the connection open unless
+// closed is set.
+func sendChunks(client net.Conn, chunks []string, closed bool) {
+	go func() {
+		for _, c := range chunks {
+			if _, err := client.Write([]byte(c)); err != nil {
+				return
+			}
+		}
+		if closed {
+			client.Close()
+		}
+	}()
+}
+
+// Probe deciding once a given length is buffered, recording the lengths it
+// was called with.
+type lengthProbe struct {
+	at     int
+	result Result
+	calls  []int
+}
+
+func (p *lengthProbe) Probe(b []byte) (Result, int) {
+	p.calls = append(p.calls, len(b))
+	if len(b) < p.at {
+		return NeedMore, p.at
+	}
+	return p.result, 0
+}
+
+func TestDetect(t *testing.T) {
+	never := ProbeFunc(func([]byte) (Result, int) { return NeedMore, 0 })
+	tests := []struct {
+		name      string
+		maxBuffer int
+		chunks    []string
+		closed    bool
+		probes    []Probe
+		want      int
+		err       error
+	}{
+		{
+			name:   "first match wins",
+			chunks: []string{"GET / HTTP/1.1\r\n"},
+			probes: []Probe{TLS, HTTP1},
+			want:   1,
+		},
+		{
+			name:   "earlier probe decides first",
+			chunks: []string{"ab", "cd"},
+			probes: []Probe{&lengthProbe{at: 4, result: Match}, spokeProbe},
+			want:   0,
+		},
+		{
+			name:   "later probe after a refusal",
+			chunks: []string{"ab", "cd"},
+			probes: []Probe{&lengthProbe{at: 4, result: NoMatch}, spokeProbe},
+			want:   1,
+		},
+		{
+			name:   "no match",
+			chunks: []string{"SSH-2.0-x\r\n"},
+			probes: []Probe{TLS, HTTP1},
+			want:   -1,
+			err:    errNoMatch,
+		},
+		{
+			name:   "short client hello",
+			chunks: []string{"\x16\x03\x01"},
+			probes: []Probe{HTTP1, TLS},
+			want:   1,
+		},
+		{
+			name:   "short greeting behind other probes",
+			chunks: []string{"\x05\x01\x00"},
+			probes: []Probe{TLS, HTTP1, spokeProbe},
+			want:   2,
+		},
+		{
+			name:   "short method",
+			chunks: []string{"GE", "T "},
+			probes: []Probe{TLS, HTTP1},
+			want:   1,
+		},
+		{
+			name:   "end of input",
+			chunks: []string{"GE"},
+			closed: true,
+			probes: []Probe{HTTP1},
+			want:   -1,
+			err:    io.EOF,
+		},
+		{
+			name:      "max buffer",
+			maxBuffer: 8,
+			chunks:    []string{"0123", "4567", "89ab"},
+			probes:    []Probe{never},
+			want:      -1,
+			err:       errMaxBuffer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, client := testPipe(t)
+			if tt.maxBuffer > 0 {
+				s.MaxBuffer = tt.maxBuffer
+			}
+			sendChunks(client, tt.chunks, tt.closed)
+			got, err := s.Detect(tt.probes...)
+			if got != tt.want || err != tt.err {
+				t.Errorf("Detect() = %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestDetectNeedMore(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		at     int
+		calls  []int
+	}{
+		{"hint skips reads", []string{"abc", "def", "ghi", "jkl"}, 10, []int{0, 12}},
+		{"hint met exactly", []string{"abcd", "efgh"}, 8, []int{0, 8}},
+		{"hint already met", []string{"abcdefgh"}, 4, []int{0, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, client := testPipe(t)
+			sendChunks(client, tt.chunks, false)
+			p := &lengthProbe{at: tt.at, result: Match}
+			if _, err := s.Detect(p); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.calls, tt.calls) {
+				t.Errorf("probe called with %v, want %v", p.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDetectThenPipe(t *testing.T) {
+	s, client := testPipe(t)
+	sendChunks(client, []string{"GET / HTTP/1.1\r\n"}, true)
+	if _, err := s.Detect(HTTP1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Pipe(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("piped input %q", b)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	s, client := testPipe(t)
+	sendChunks(client, []string{"hello", "world"}, false)
+	replies := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 3)
+		io.ReadFull(client, b)
+		replies <- b
+	}()
+
+	hello := make([]byte, 5)
+	if _, err := io.ReadFull(s, hello); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteThrough([]byte("ack")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-replies; string(got) != "ack" {
+		t.Errorf("client got %q", got)
+	}
+
+	// Detect and Replay only see what follows the commit
+	if _, err := s.Detect(&lengthProbe{at: 5, result: Match}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Buffered(); string(got) != "world" {
+		t.Errorf("Buffered() = %q", got)
+	}
+	b := make([]byte, 5)
+	io.ReadFull(s, b)
+	s.Replay()
+	io.ReadFull(s, b)
+	if string(b) != "world" {
+		t.Errorf("read %q after Replay", b)
+	}
+
+	in, out := s.Committed()
+	if string(in) != "hello" || string(out) != "ack" {
+		t.Errorf("Committed() = %q, %q", in, out)
+	}
+}
+
+func TestWriteThroughPending(t *testing.T) {
+	s, _ := testPipe(t)
+	s.Write([]byte("queued"))
+	if _, err := s.WriteThrough([]byte("now")); err != errPending {
+		t.Errorf("WriteThrough() = %v, want %v", err, errPending)
+	}
+	if in, out := s.Committed(); in != nil || out != nil {
+		t.Errorf("Committed() = %q, %q", in, out)
+	}
+}
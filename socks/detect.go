@@ -0,0 +1,168 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	// Returned by Parse while the greeting is not complete
+	ErrIncomplete = errors.New("socks: incomplete greeting")
+	errMalformed  = errors.New("socks: not a SOCKS greeting")
+)
+
+// Longest user id or host name accepted in a SOCKS4 request
+const maxSocks4Field = 255
+
+// SOCKS4 commands, also used by SOCKS5
+const (
+	CmdConnect   byte = 0x01
+	CmdBind      byte = 0x02
+	CmdAssociate byte = 0x03
+)
+
+// SOCKS5 authentication methods
+const (
+	MethodNoAuth       byte = 0x00
+	MethodGSSAPI       byte = 0x01
+	MethodUserPass     byte = 0x02
+	MethodNoAcceptable byte = 0xff
+)
+
+// Greeting is the first message of a SOCKS client.
+type Greeting struct {
+	// Protocol version, 4 or 5.  SOCKS4a is reported as 4 with Host set.
+	Version int
+
+	// SOCKS4 request fields
+	Command byte
+	Port    uint16
+	IP      net.IP
+	UserID  string
+	Host    string
+
+	// Authentication methods offered by a SOCKS5 client
+	Methods []byte
+
+	// Length of the greeting
+	Len int
+}
+
+// Parse reads the greeting at the start of b, a SOCKS4 request or a SOCKS5
+// method selection.  ErrIncomplete is returned while more bytes are needed.
+func Parse(b []byte) (*Greeting, error) {
+	g, _, err := parse(b)
+	return g, err
+}
+
+func parse(b []byte) (*Greeting, int, error) {
+	if len(b) < 1 {
+		return nil, 1, ErrIncomplete
+	}
+	switch b[0] {
+	case 4:
+		return parse4(b)
+	case 5:
+		return parse5(b)
+	}
+	return nil, 0, errMalformed
+}
+
+// VN CD DSTPORT DSTIP USERID NULL, followed by HOST NULL for SOCKS4a
+func parse4(b []byte) (*Greeting, int, error) {
+	// The command rules out most inputs starting with a 4
+	if len(b) < 2 {
+		return nil, 2, ErrIncomplete
+	}
+	if b[1] != CmdConnect && b[1] != CmdBind {
+		return nil, 0, errMalformed
+	}
+	if len(b) < 8 {
+		return nil, 8, ErrIncomplete
+	}
+	g := &Greeting{
+		Version: 4,
+		Command: b[1],
+		Port:    uint16(b[2])<<8 | uint16(b[3]),
+		IP:      net.IPv4(b[4], b[5], b[6], b[7]),
+	}
+
+	off := 8
+	field := func() (string, bool) {
+		i := bytes.IndexByte(b[off:], 0)
+		if i < 0 {
+			return "", false
+		}
+		v := string(b[off : off+i])
+		off += i + 1
+		return v, true
+	}
+	var ok bool
+	if g.UserID, ok = field(); !ok {
+		if len(b)-8 > maxSocks4Field {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+
+	// An address of 0.0.0.x with x non zero announces a SOCKS4a host name
+	if b[4] == 0 && b[5] == 0 && b[6] == 0 && b[7] != 0 {
+		start := off
+		if g.Host, ok = field(); !ok || g.Host == "" {
+			if !ok && len(b)-start <= maxSocks4Field {
+				return nil, len(b) + 1, ErrIncomplete
+			}
+			return nil, 0, errMalformed
+		}
+		g.IP = nil
+	}
+	g.Len = off
+	return g, 0, nil
+}
+
+// VER NMETHODS METHODS
+func parse5(b []byte) (*Greeting, int, error) {
+	if len(b) < 2 {
+		return nil, 2, ErrIncomplete
+	}
+	n := int(b[1])
+	if n == 0 {
+		return nil, 0, errMalformed
+	}
+	if len(b) < 2+n {
+		return nil, 2 + n, ErrIncomplete
+	}
+	return &Greeting{
+		Version: 5,
+		Methods: append([]byte(nil), b[2:2+n]...),
+		Len:     2 + n,
+	}, 0, nil
+}
+
+// Version returns a probe matching the greetings of the given protocol
+// version, 4 or 5, or any version when zero.
+func Version(v int) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		g, need, err := parse(b)
+		return err == nil && (v == 0 || g.Version == v), need, err
+	})
+}
+
+// Probes matching any SOCKS client, or only the given version.
+var (
+	Probe  = Version(0)
+	Probe4 = Version(4)
+	Probe5 = Version(5)
+)
+
+// Read waits for the greeting on the teaser and parses it.  No input is
+// consumed.
+func Read(s *tease.Server) (*Greeting, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
@@ -0,0 +1,6 @@
+/*
+Package socks detects SOCKS4, SOCKS4a and SOCKS5 clients on a teaser, and
+offers a built-in SOCKS5 proxy handler so that a SOCKS jump endpoint can share
+a port with other protocols.
+*/
+package socks
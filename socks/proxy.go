@@ -0,0 +1,262 @@
+package socks
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// SOCKS5 reply codes
+const (
+	ReplySucceeded           byte = 0x00
+	ReplyGeneralFailure      byte = 0x01
+	ReplyNotAllowed          byte = 0x02
+	ReplyNetworkUnreachable  byte = 0x03
+	ReplyHostUnreachable     byte = 0x04
+	ReplyConnectionRefused   byte = 0x05
+	ReplyCommandNotSupported byte = 0x07
+	ReplyAddressNotSupported byte = 0x08
+)
+
+// Address types of SOCKS5 requests
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+var errAuth = errors.New("socks: authentication failed")
+
+// Proxy is a tease.Handler serving SOCKS5 clients with the CONNECT command.
+// Clients authenticate with no authentication, or with a username and
+// password (RFC 1929) when Authenticate is set.
+type Proxy struct {
+	// Optional check of the client credentials.  When set, only the username
+	// and password method is offered.
+	Authenticate func(user, password string) bool
+
+	// Check of the target, given as the host and port the client asked for,
+	// before it is dialed.  All targets are refused when nil, so that the
+	// proxy is never open by default.
+	Allow func(host string, port int) bool
+
+	// Time allowed to connect to the requested target, past which the
+	// CONNECT gets a general failure reply.  Zero waits as long as the system
+	// does; not used with Dial.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the targets.
+	Dial func(network, addr string) (net.Conn, error)
+}
+
+// ServeTease runs the SOCKS5 negotiation on the teaser, pipes it and splices
+// the connection to the requested target.  Other SOCKS versions are dropped.
+//
+// The replies are sent with write throughs, so the negotiation is bounded by
+// the read deadline of the Mux until the teaser is piped.
+func (p *Proxy) ServeTease(s *tease.Server) {
+	g, err := Read(s)
+	if err != nil || g.Version != 5 {
+		s.Abort()
+		return
+	}
+
+	target, err := p.negotiate(negotiation{s})
+	if err != nil {
+		s.Abort()
+		return
+	}
+	if err := s.Pipe(); err != nil {
+		target.Close()
+		s.Abort()
+		return
+	}
+	tease.Splice(s, target)
+}
+
+// Teaser whose writes go through right away, staying in tease mode.
+type negotiation struct {
+	*tease.Server
+}
+
+func (n negotiation) Write(b []byte) (int, error) {
+	return n.WriteThrough(b)
+}
+
+// Run the method selection, authentication and request.
+func (p *Proxy) negotiate(conn net.Conn) (net.Conn, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+
+	want := MethodNoAuth
+	if p.Authenticate != nil {
+		want = MethodUserPass
+	}
+	if !hasMethod(methods, want) {
+		conn.Write([]byte{5, MethodNoAcceptable})
+		return nil, errAuth
+	}
+	if _, err := conn.Write([]byte{5, want}); err != nil {
+		return nil, err
+	}
+	if want == MethodUserPass {
+		if err := p.userPass(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	host, port, cmd, err := readRequest(conn)
+	if err != nil {
+		if err == errAddressType {
+			writeReply(conn, ReplyAddressNotSupported, nil)
+		}
+		return nil, err
+	}
+	if cmd != CmdConnect {
+		writeReply(conn, ReplyCommandNotSupported, nil)
+		return nil, errors.New("socks: unsupported command")
+	}
+	if p.Allow == nil || !p.Allow(host, port) {
+		writeReply(conn, ReplyNotAllowed, nil)
+		return nil, errors.New("socks: target not allowed")
+	}
+
+	target, err := p.dial(net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		writeReply(conn, dialReply(err), nil)
+		return nil, err
+	}
+	if err := writeReply(conn, ReplySucceeded, target.LocalAddr()); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+func hasMethod(methods []byte, m byte) bool {
+	for _, v := range methods {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// VER ULEN UNAME PLEN PASSWD of RFC 1929
+func (p *Proxy) userPass(conn net.Conn) error {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return err
+	}
+	user := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, hdr[:1]); err != nil {
+		return err
+	}
+	password := make([]byte, hdr[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+	if !p.Authenticate(string(user), string(password)) {
+		conn.Write([]byte{1, 1})
+		return errAuth
+	}
+	_, err := conn.Write([]byte{1, 0})
+	return err
+}
+
+var errAddressType = errors.New("socks: unsupported address type")
+
+// VER CMD RSV ATYP DST.ADDR DST.PORT
+func readRequest(conn net.Conn) (host string, port int, cmd byte, err error) {
+	hdr := make([]byte, 4)
+	if _, err = io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	if hdr[0] != 5 {
+		return "", 0, 0, errMalformed
+	}
+	cmd = hdr[1]
+
+	var addr []byte
+	switch hdr[3] {
+	case atypIPv4:
+		addr = make([]byte, net.IPv4len)
+	case atypIPv6:
+		addr = make([]byte, net.IPv6len)
+	case atypDomain:
+		if _, err = io.ReadFull(conn, hdr[:1]); err != nil {
+			return
+		}
+		addr = make([]byte, hdr[0])
+	default:
+		return "", 0, 0, errAddressType
+	}
+	if _, err = io.ReadFull(conn, addr); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(conn, hdr[:2]); err != nil {
+		return
+	}
+	port = int(hdr[0])<<8 | int(hdr[1])
+	if hdr[3] == atypDomain {
+		host = string(addr)
+	} else {
+		host = net.IP(addr).String()
+	}
+	return
+}
+
+// VER REP RSV ATYP BND.ADDR BND.PORT
+func writeReply(conn net.Conn, rep byte, bound net.Addr) error {
+	reply := []byte{5, rep, 0, atypIPv4, 0, 0, 0, 0, 0, 0}
+	if a, ok := bound.(*net.TCPAddr); ok {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			copy(reply[4:8], ip4)
+		} else {
+			reply = append(reply[:3], atypIPv6)
+			reply = append(reply, a.IP.To16()...)
+			reply = append(reply, 0, 0)
+		}
+		reply[len(reply)-2], reply[len(reply)-1] = byte(a.Port>>8), byte(a.Port)
+	}
+	_, err := conn.Write(reply)
+	return err
+}
+
+// Map a dial error to the closest reply code.
+func dialReply(err error) byte {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return ReplyHostUnreachable
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ReplyHostUnreachable
+	}
+	return ReplyGeneralFailure
+}
+
+func (p *Proxy) dial(addr string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial("tcp", addr)
+	}
+	return net.DialTimeout("tcp", addr, p.DialTimeout)
+}
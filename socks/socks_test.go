@@ -0,0 +1,296 @@
+package socks
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *Greeting
+	}{
+		{
+			name: "socks4",
+			in:   "\x04\x01\x00\x50\xc0\x00\x02\x01bob\x00",
+			want: &Greeting{Version: 4, Command: CmdConnect, Port: 80, IP: net.IPv4(192, 0, 2, 1), UserID: "bob", Len: 12},
+		},
+		{
+			name: "socks4a",
+			in:   "\x04\x02\x01\xbb\x00\x00\x00\x01\x00example.com\x00rest",
+			want: &Greeting{Version: 4, Command: CmdBind, Port: 443, Host: "example.com", Len: 21},
+		},
+		{
+			name: "socks5",
+			in:   "\x05\x02\x00\x02\x05\x01",
+			want: &Greeting{Version: 5, Methods: []byte{MethodNoAuth, MethodUserPass}, Len: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, tt.want) {
+				t.Errorf("got %+v, want %+v", g, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		need int
+	}{
+		{"empty", "", 1},
+		{"version 4 alone", "\x04", 2},
+		{"short socks4", "\x04\x01\x00", 8},
+		{"socks4 without user id end", "\x04\x01\x00\x50\xc0\x00\x02\x01bo", 11},
+		{"socks4a without host end", "\x04\x01\x00\x50\x00\x00\x00\x01\x00exa", 13},
+		{"version 5 alone", "\x05", 2},
+		{"short methods", "\x05\x03\x00", 5},
+		{"socks4 bad command", "\x04\x03", 0},
+		{"socks4 command zero", "\x04\x00\x00\x50", 0},
+		{"socks4a empty host", "\x04\x01\x00\x50\x00\x00\x00\x01\x00\x00", 0},
+		{"socks5 no methods", "\x05\x00", 0},
+		{"other version", "GET ", 0},
+	}
+	for _, tt := range tests {
+		g, need, err := parse([]byte(tt.in))
+		if err == nil || need != tt.need || tt.need > 0 && err != ErrIncomplete {
+			t.Errorf("%s: got %+v, %d, %v, want need %d", tt.name, g, need, err, tt.need)
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		in     string
+		probe  tease.Probe
+		result tease.Result
+	}{
+		{"\x05\x01\x00", Probe5, tease.Match},
+		{"\x05\x01\x00", Probe4, tease.NoMatch},
+		{"\x04\x01\x00\x50\xc0\x00\x02\x01\x00", Probe, tease.Match},
+		{"\x04", Probe, tease.NeedMore},
+		{"\x04\x05", Probe4, tease.NoMatch},
+	}
+	for _, tt := range tests {
+		if r, _ := tt.probe.Probe([]byte(tt.in)); r != tt.result {
+			t.Errorf("Probe(%q) = %v, want %v", tt.in, r, tt.result)
+		}
+	}
+}
+
+// Echo server on the loopback, returning its port.
+func echoServer(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// CONNECT request for the host and port.
+func request(cmd byte, host string, port int) string {
+	req := []byte{5, cmd, 0}
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		req = append(append(req, atypIPv4), ip...)
+	} else {
+		req = append(append(req, atypDomain, byte(len(host))), host...)
+	}
+	return string(append(req, byte(port>>8), byte(port)))
+}
+
+func TestProxy(t *testing.T) {
+	port := echoServer(t)
+	allow := func(host string, p int) bool { return p == port }
+	auth := func(user, password string) bool { return user == "bob" && password == "secret" }
+	tests := []struct {
+		name  string
+		proxy *Proxy
+		in    string
+		// Replies expected up to the connect reply, of which only the code is
+		// compared, unless the connection is dropped before
+		replies string
+		code    byte
+		dropped bool
+	}{
+		{
+			name:    "no authentication",
+			proxy:   &Proxy{Allow: allow},
+			in:      "\x05\x01\x00" + request(CmdConnect, "127.0.0.1", port),
+			replies: "\x05\x00",
+			code:    ReplySucceeded,
+		},
+		{
+			name:    "domain name",
+			proxy:   &Proxy{Allow: allow},
+			in:      "\x05\x02\x02\x00" + request(CmdConnect, "localhost", port),
+			replies: "\x05\x00",
+			code:    ReplySucceeded,
+		},
+		{
+			name:    "username and password",
+			proxy:   &Proxy{Allow: allow, Authenticate: auth},
+			in:      "\x05\x02\x00\x02" + "\x01\x03bob\x06secret" + request(CmdConnect, "127.0.0.1", port),
+			replies: "\x05\x02\x01\x00",
+			code:    ReplySucceeded,
+		},
+		{
+			name:    "wrong password",
+			proxy:   &Proxy{Allow: allow, Authenticate: auth},
+			in:      "\x05\x01\x02" + "\x01\x03bob\x05guess",
+			replies: "\x05\x02\x01\x01",
+			dropped: true,
+		},
+		{
+			name:    "password method not offered",
+			proxy:   &Proxy{Allow: allow, Authenticate: auth},
+			in:      "\x05\x01\x00",
+			replies: "\x05\xff",
+			dropped: true,
+		},
+		{
+			name:    "no authentication not offered",
+			proxy:   &Proxy{Allow: allow},
+			in:      "\x05\x01\x02",
+			replies: "\x05\xff",
+			dropped: true,
+		},
+		{
+			name:    "closed by default",
+			proxy:   &Proxy{},
+			in:      "\x05\x01\x00" + request(CmdConnect, "127.0.0.1", port),
+			replies: "\x05\x00",
+			code:    ReplyNotAllowed,
+		},
+		{
+			name:    "target not allowed",
+			proxy:   &Proxy{Allow: allow},
+			in:      "\x05\x01\x00" + request(CmdConnect, "127.0.0.1", port+1),
+			replies: "\x05\x00",
+			code:    ReplyNotAllowed,
+		},
+		{
+			name:    "bind",
+			proxy:   &Proxy{Allow: allow},
+			in:      "\x05\x01\x00" + request(CmdBind, "127.0.0.1", port),
+			replies: "\x05\x00",
+			code:    ReplyCommandNotSupported,
+		},
+		{
+			name:    "address type",
+			proxy:   &Proxy{Allow: allow},
+			in:      "\x05\x01\x00" + "\x05\x01\x00\x07",
+			replies: "\x05\x00",
+			code:    ReplyAddressNotSupported,
+		},
+		{
+			name:    "refused",
+			proxy:   &Proxy{Allow: func(string, int) bool { return true }},
+			in:      "\x05\x01\x00" + request(CmdConnect, "127.0.0.1", 1),
+			replies: "\x05\x00",
+			code:    ReplyConnectionRefused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer b.Close()
+			b.SetDeadline(time.Now().Add(2 * time.Second))
+			go tt.proxy.ServeTease(tease.NewServer(a))
+			go b.Write([]byte(tt.in))
+
+			got, _ := io.ReadAll(io.LimitReader(b, int64(len(tt.replies))))
+			if string(got) != tt.replies {
+				t.Fatalf("replies %x, want %x", got, tt.replies)
+			}
+			reply := make([]byte, 10)
+			_, err := io.ReadFull(b, reply)
+			if tt.dropped {
+				if err == nil {
+					t.Errorf("connection kept after %x", reply)
+				}
+				return
+			}
+			if err != nil || reply[0] != 5 || reply[1] != tt.code || reply[3] != atypIPv4 {
+				t.Fatalf("reply %x, %v", reply, err)
+			}
+			if tt.code != ReplySucceeded {
+				return
+			}
+			if !bytes.Equal(reply[4:8], []byte{127, 0, 0, 1}) || reply[8] == 0 && reply[9] == 0 {
+				t.Errorf("bound address %x", reply[4:])
+			}
+			b.Write([]byte("ping"))
+			echo := make([]byte, 4)
+			if _, err := io.ReadFull(b, echo); err != nil || string(echo) != "ping" {
+				t.Errorf("echo %q, %v", echo, err)
+			}
+		})
+	}
+}
+
+func TestWriteReply(t *testing.T) {
+	tests := []struct {
+		bound net.Addr
+		want  []byte
+	}{
+		{nil, []byte{5, 1, 0, atypIPv4, 0, 0, 0, 0, 0, 0}},
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1080}, []byte{5, 1, 0, atypIPv4, 192, 0, 2, 1, 4, 56}},
+		{
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			[]byte{5, 1, 0, atypIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 187},
+		},
+	}
+	for _, tt := range tests {
+		a, b := net.Pipe()
+		go func() {
+			writeReply(a, ReplyGeneralFailure, tt.bound)
+			a.Close()
+		}()
+		got, _ := io.ReadAll(b)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("reply for %v: %v, want %v", tt.bound, got, tt.want)
+		}
+	}
+}
+
+func TestDialReply(t *testing.T) {
+	_, err := net.Dial("tcp", "127.0.0.1:1")
+	if got := dialReply(err); got != ReplyConnectionRefused {
+		t.Errorf("dialReply(%v) = %d", err, got)
+	}
+	err = &net.DNSError{Err: "no such host", Name: "invalid.", IsNotFound: true}
+	if got := dialReply(err); got != ReplyHostUnreachable {
+		t.Errorf("dialReply(%v) = %d", err, got)
+	}
+	if got := dialReply(io.EOF); got != ReplyGeneralFailure {
+		t.Errorf("dialReply(%v) = %d", io.EOF, got)
+	}
+}
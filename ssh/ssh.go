@@ -0,0 +1,227 @@
+/*
+Package ssh detects SSH clients on a teaser from their identification line,
+and computes the HASSH fingerprint when the client KEXINIT is already in the
+teaser buffer.
+
+Most clients wait for the server identification before sending their
+KEXINIT, so the fingerprint is usually only available when the client does
+not, and detection never waits for it.
+*/
+package ssh
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	// Returned by Parse while the identification line is not complete
+	ErrIncomplete = errors.New("ssh: incomplete identification")
+	errMalformed  = errors.New("ssh: not an SSH identification")
+)
+
+const (
+	// Longest identification line, CR LF included
+	maxIdentLen = 255
+
+	msgKexInit   = 20
+	maxPacketLen = 35000
+	kexCookieLen = 16
+	kexNameLists = 10
+)
+
+// Hello is the start of an SSH client connection.
+type Hello struct {
+	// Identification line, without the CR LF
+	Ident string
+
+	// Fields of "SSH-protoversion-softwareversion SP comments"
+	ProtoVersion string
+	Software     string
+	Comments     string
+
+	// Key exchange init sent by the client, nil when not buffered yet
+	KexInit *KexInit
+}
+
+// KexInit holds the algorithm lists of the client SSH_MSG_KEXINIT.
+type KexInit struct {
+	Kex          []string
+	HostKey      []string
+	CiphersC2S   []string
+	CiphersS2C   []string
+	MACsC2S      []string
+	MACsS2C      []string
+	CompressC2S  []string
+	CompressS2C  []string
+	LanguagesC2S []string
+	LanguagesS2C []string
+
+	FirstKexFollows bool
+
+	// MD5 of HASSHAlgorithms
+	HASSH string
+
+	// "kex;ciphers;macs;compression" of the client to server direction
+	HASSHAlgorithms string
+}
+
+// Parse reads the identification line at the start of b, followed by the
+// KEXINIT packet if it is complete.  ErrIncomplete is returned until the line
+// is complete.
+func Parse(b []byte) (*Hello, error) {
+	h, _, err := parse(b)
+	return h, err
+}
+
+func parse(b []byte) (*Hello, int, error) {
+	const prefix = "SSH-"
+	if len(b) < len(prefix) {
+		if !bytes.HasPrefix([]byte(prefix), b) {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+	if !bytes.HasPrefix(b, []byte(prefix)) {
+		return nil, 0, errMalformed
+	}
+
+	end := bytes.IndexByte(b, '\n')
+	if end < 0 {
+		if len(b) >= maxIdentLen {
+			return nil, 0, errMalformed
+		}
+		return nil, len(b) + 1, ErrIncomplete
+	}
+	if end+1 > maxIdentLen {
+		return nil, 0, errMalformed
+	}
+
+	h := &Hello{Ident: string(bytes.TrimSuffix(b[:end], []byte("\r")))}
+	ident := h.Ident[len(prefix):]
+	if i := strings.IndexByte(ident, ' '); i >= 0 {
+		ident, h.Comments = ident[:i], ident[i+1:]
+	}
+	i := strings.IndexByte(ident, '-')
+	if i <= 0 || i == len(ident)-1 {
+		return nil, 0, errMalformed
+	}
+	h.ProtoVersion, h.Software = ident[:i], ident[i+1:]
+
+	h.KexInit = parseKexInit(b[end+1:])
+	return h, 0, nil
+}
+
+// Parse the binary packet holding the KEXINIT, nil when incomplete or not
+// a KEXINIT.
+func parseKexInit(b []byte) *KexInit {
+	if len(b) < 5 {
+		return nil
+	}
+	l := int(binary.BigEndian.Uint32(b))
+	pad := int(b[4])
+	if l > maxPacketLen || len(b) < 4+l || pad+1 > l {
+		return nil
+	}
+	payload := b[5 : 4+l-pad]
+	if len(payload) < 1+kexCookieLen || payload[0] != msgKexInit {
+		return nil
+	}
+	payload = payload[1+kexCookieLen:]
+
+	var lists [kexNameLists][]string
+	for i := range lists {
+		if len(payload) < 4 {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(payload))
+		if len(payload) < 4+n {
+			return nil
+		}
+		if n > 0 {
+			lists[i] = strings.Split(string(payload[4:4+n]), ",")
+		}
+		payload = payload[4+n:]
+	}
+	if len(payload) < 1 {
+		return nil
+	}
+
+	k := &KexInit{
+		Kex: lists[0], HostKey: lists[1],
+		CiphersC2S: lists[2], CiphersS2C: lists[3],
+		MACsC2S: lists[4], MACsS2C: lists[5],
+		CompressC2S: lists[6], CompressS2C: lists[7],
+		LanguagesC2S: lists[8], LanguagesS2C: lists[9],
+		FirstKexFollows: payload[0] != 0,
+	}
+	k.HASSHAlgorithms = strings.Join([]string{
+		strings.Join(k.Kex, ","),
+		strings.Join(k.CiphersC2S, ","),
+		strings.Join(k.MACsC2S, ","),
+		strings.Join(k.CompressC2S, ","),
+	}, ";")
+	sum := md5.Sum([]byte(k.HASSHAlgorithms))
+	k.HASSH = hex.EncodeToString(sum[:])
+	return k
+}
+
+// Matching returns a probe matching the clients for which the function
+// returns true, once their identification line is complete.  The KexInit
+// field is only set if the client sent it along.
+func Matching(match func(h *Hello) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		h, need, err := parse(b)
+		return err == nil && match(h), need, err
+	})
+}
+
+// Probe matches any SSH client.
+var Probe = Matching(func(*Hello) bool { return true })
+
+// Software returns a probe matching the clients whose software version
+// matches any of the glob patterns of path.Match, such as "OpenSSH_*".
+func Software(patterns ...string) tease.Probe {
+	return Matching(func(h *Hello) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, h.Software); ok {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// HASSH returns a probe matching the clients with any of the given HASSH
+// fingerprints.  Clients whose KEXINIT is not buffered along with their
+// identification line never match.
+func HASSH(fingerprints ...string) tease.Probe {
+	set := make(map[string]bool, len(fingerprints))
+	for _, f := range fingerprints {
+		set[strings.ToLower(f)] = true
+	}
+	return Matching(func(h *Hello) bool {
+		return h.KexInit != nil && set[h.KexInit.HASSH]
+	})
+}
+
+// Legacy matches clients which do not speak SSH 2.0, to be refused.
+var Legacy = Matching(func(h *Hello) bool {
+	return h.ProtoVersion != "2.0" && h.ProtoVersion != "1.99"
+})
+
+// Read waits for the identification line on the teaser and parses it, along
+// with the KEXINIT if already buffered.  No input is consumed.
+func Read(s *tease.Server) (*Hello, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
@@ -0,0 +1,213 @@
+package starttls
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var errBackend = errors.New("starttls: backend refused STARTTLS")
+
+// Prepare replays the dialogue to a backend, reading its replies, so that the
+// backend is about to start TLS too.  The teased connection can then be
+// spliced to it, see Router.  An error is returned when the backend does not
+// greet properly or does not accept the STARTTLS command.
+//
+// The client only saw the capabilities announced by Negotiate, but as both
+// sides start over once TLS is up the backend's own are not missed.
+func (sess *Session) Prepare(backend net.Conn) error {
+	if sess.Protocol == XMPP {
+		return sess.prepareXMPP(backend)
+	}
+
+	// Server-first protocols greet before the commands are replayed
+	if ok, err := sess.response(backend, nil); err != nil {
+		return err
+	} else if !ok {
+		return errBackend
+	}
+	for i, cmd := range sess.Commands {
+		if _, err := backend.Write(cmd); err != nil {
+			return err
+		}
+		ok, err := sess.response(backend, cmd)
+		if err != nil {
+			return err
+		}
+		if i == len(sess.Commands)-1 && !ok {
+			return errBackend
+		}
+	}
+	return nil
+}
+
+// Read the backend response to a command, or the greeting for a nil command,
+// and tell if it was positive.
+func (sess *Session) response(conn net.Conn, cmd []byte) (ok bool, err error) {
+	var last []byte
+	switch sess.Protocol {
+	case SMTP:
+		// Multiline replies have a dash after the code on all but the last line
+		for {
+			if last, err = readLine(conn); err != nil {
+				return
+			}
+			if len(last) < 4 || last[3] != '-' {
+				return last[0] == '2', nil
+			}
+		}
+	case IMAP:
+		// Untagged lines come before the tagged completion of the command
+		f := bytes.Fields(cmd)
+		if len(f) < 2 {
+			last, err = readLine(conn)
+			return bytes.HasPrefix(last, []byte("* OK")), err
+		}
+		tag := string(f[0]) + " "
+		for {
+			if last, err = readLine(conn); err != nil {
+				return
+			}
+			if strings.HasPrefix(string(last), tag) {
+				return bytes.HasPrefix(last[len(tag):], []byte("OK")), nil
+			}
+		}
+	case POP3:
+		if last, err = readLine(conn); err != nil {
+			return
+		}
+		ok = bytes.HasPrefix(last, []byte("+OK"))
+		if verb, _ := split(cmd); ok && verb == "CAPA" {
+			// The capability list runs up to a line with a single dot
+			for string(bytes.TrimRight(last, "\r\n")) != "." {
+				if last, err = readLine(conn); err != nil {
+					return
+				}
+			}
+		}
+		return
+	}
+	return false, errBackend
+}
+
+func (sess *Session) prepareXMPP(backend net.Conn) error {
+	if _, err := backend.Write(sess.Commands[0]); err != nil {
+		return err
+	}
+	for {
+		tag, err := readTag(backend)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(tag, []byte("</stream:features")) {
+			break
+		}
+		if bytes.HasPrefix(tag, []byte("<stream:error")) {
+			return errBackend
+		}
+	}
+	if _, err := backend.Write(sess.Commands[len(sess.Commands)-1]); err != nil {
+		return err
+	}
+	for {
+		tag, err := readTag(backend)
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(tag, []byte("<proceed")):
+			if !bytes.HasSuffix(tag, []byte("/>")) {
+				_, err = readTag(backend)
+			}
+			return err
+		case bytes.HasPrefix(tag, []byte("<failure")), bytes.HasPrefix(tag, []byte("<stream:error")):
+			return errBackend
+		}
+	}
+}
+
+// Read a line off the connection one byte at a time, so nothing past it is
+// taken away from the TLS handshake.
+func readLine(conn net.Conn) ([]byte, error) {
+	return readTo(conn, '\n')
+}
+
+// Read the next XML tag off the connection, without the whitespace in front.
+func readTag(conn net.Conn) ([]byte, error) {
+	b, err := readTo(conn, '>')
+	return bytes.TrimSpace(b), err
+}
+
+func readTo(conn net.Conn, delim byte) ([]byte, error) {
+	var b []byte
+	c := []byte{0}
+	for len(b) < maxLine {
+		if _, err := conn.Read(c); err != nil {
+			return nil, err
+		}
+		b = append(b, c[0])
+		if c[0] == delim {
+			return b, nil
+		}
+	}
+	return nil, errBackend
+}
+
+// Longest line or tag accepted from a backend
+const maxLine = 4096
+
+// Router is a tease.Handler which speaks the plaintext dialogue with clients,
+// then forwards them to the backend picked by Route.  The backend goes
+// through the same dialogue before the connection is spliced, so the TLS
+// session is passed through end to end.
+type Router struct {
+	Protocol Protocol
+
+	// Name announced in the greeting
+	Hostname string
+
+	// Pick the backend address for a session, or return an empty address to
+	// drop it.  The ClientHello can be inspected on sess.Conn, for instance
+	// with tls.Read to route by SNI.
+	Route func(sess *Session) string
+
+	// Time allowed to reach the backend, which then has the plaintext
+	// dialogue replayed to it up to STARTTLS while the client waits on its
+	// TLS handshake.  Zero leaves it to the operating system.
+	DialTimeout time.Duration
+
+	// Optional dial function to connect to the backends.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// PROXY protocol version of the header sent to the backends ahead of the
+	// replayed dialogue, zero for none.
+	ProxyProtocol int
+}
+
+// ServeTease negotiates, picks the backend and splices the connection to it.
+func (r *Router) ServeTease(s *tease.Server) {
+	sess, err := Negotiate(s, r.Protocol, r.Hostname)
+	if err != nil {
+		s.Abort()
+		return
+	}
+	addr := r.Route(sess)
+	if addr == "" {
+		s.Abort()
+		return
+	}
+
+	f := &tease.Forwarder{
+		Addr:          addr,
+		DialTimeout:   r.DialTimeout,
+		Dial:          r.Dial,
+		ProxyProtocol: r.ProxyProtocol,
+		Protocol:      strings.ToLower(r.Protocol.String()),
+		Prepare:       sess.Prepare,
+	}
+	f.ServeTease(s)
+}
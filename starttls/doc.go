@@ -0,0 +1,10 @@
+/*
+Package starttls speaks the plaintext opening of SMTP, IMAP, POP3 and XMPP on
+a teaser, up to the point where the client upgrades with STARTTLS or STLS.
+
+The greeting and the replies are sent with write throughs, so once the client
+has been given the go-ahead teasing continues on the TLS ClientHello, which
+can be inspected for the SNI as usual.  The dialogue is recorded so it can be
+replayed to the backend before the connection is spliced to it.
+*/
+package starttls
@@ -0,0 +1,358 @@
+package starttls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+var (
+	errQuit     = errors.New("starttls: client quit before STARTTLS")
+	errRefused  = errors.New("starttls: client did not ask for STARTTLS")
+	errTooMany  = errors.New("starttls: too many commands before STARTTLS")
+	errNoStream = errors.New("starttls: not an XMPP stream")
+)
+
+// Commands allowed before the client must have asked for STARTTLS
+const maxCommands = 20
+
+// Protocol is the plaintext protocol spoken before the upgrade.
+type Protocol int
+
+const (
+	SMTP Protocol = iota
+	IMAP
+	POP3
+	XMPP
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case SMTP:
+		return "SMTP"
+	case IMAP:
+		return "IMAP"
+	case POP3:
+		return "POP3"
+	case XMPP:
+		return "XMPP"
+	}
+	return "unknown"
+}
+
+// Session is the outcome of the plaintext dialogue with a client.
+type Session struct {
+	Protocol Protocol
+
+	// Name the client gave for itself in the SMTP EHLO or HELO, or the domain
+	// it addressed in the XMPP stream header
+	Name string
+
+	// Commands sent by the client, in order, ending with the one asking for
+	// STARTTLS
+	Commands [][]byte
+
+	// Teaser the dialogue took place on, still in tease mode with its input
+	// committed up to the start of the TLS handshake
+	Conn *tease.Server
+}
+
+// Negotiate greets the client on the teaser, announcing hostname, and answers
+// its commands until it asks to start TLS.  Commands other than the
+// capability queries, NOOP and STARTTLS itself are refused as TLS is
+// required.  On return the client is starting TLS on Session.Conn, so the
+// ClientHello can be read with tls.Read.
+//
+// SMTP, IMAP and POP3 are server-first protocols, so the teaser would
+// typically come from a Mux silence route.  XMPP clients open the stream
+// themselves, see XMPPProbe.
+func Negotiate(s *tease.Server, p Protocol, hostname string) (*Session, error) {
+	sess := &Session{Protocol: p, Conn: s}
+	s.Replay()
+	var err error
+	switch p {
+	case SMTP:
+		err = sess.smtp(hostname)
+	case IMAP:
+		err = sess.imap(hostname)
+	case POP3:
+		err = sess.pop3(hostname)
+	case XMPP:
+		err = sess.xmpp(hostname)
+	default:
+		err = fmt.Errorf("starttls: unknown protocol %d", p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (sess *Session) smtp(hostname string) error {
+	s := sess.Conn
+	if err := reply(s, "220 %s ESMTP\r\n", hostname); err != nil {
+		return err
+	}
+	for len(sess.Commands) < maxCommands {
+		line, err := sess.readLine()
+		if err != nil {
+			return err
+		}
+		verb, arg := split(line)
+		switch verb {
+		case "EHLO":
+			sess.Name = arg
+			err = reply(s, "250-%s\r\n250 STARTTLS\r\n", hostname)
+		case "HELO":
+			sess.Name = arg
+			err = reply(s, "250 %s\r\n", hostname)
+		case "STARTTLS":
+			return reply(s, "220 2.0.0 Ready to start TLS\r\n")
+		case "NOOP", "RSET":
+			err = reply(s, "250 2.0.0 OK\r\n")
+		case "QUIT":
+			reply(s, "221 2.0.0 Bye\r\n")
+			return errQuit
+		default:
+			err = reply(s, "530 5.7.0 Must issue a STARTTLS command first\r\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errTooMany
+}
+
+func (sess *Session) imap(hostname string) error {
+	s := sess.Conn
+	const caps = "IMAP4rev1 STARTTLS LOGINDISABLED"
+	if err := reply(s, "* OK [CAPABILITY %s] %s ready\r\n", caps, hostname); err != nil {
+		return err
+	}
+	for len(sess.Commands) < maxCommands {
+		line, err := sess.readLine()
+		if err != nil {
+			return err
+		}
+		f := strings.Fields(string(line))
+		if len(f) < 2 {
+			if err = reply(s, "* BAD Invalid command\r\n"); err != nil {
+				return err
+			}
+			continue
+		}
+		tag, verb := f[0], strings.ToUpper(f[1])
+		switch verb {
+		case "CAPABILITY":
+			err = reply(s, "* CAPABILITY %s\r\n%s OK CAPABILITY completed\r\n", caps, tag)
+		case "STARTTLS":
+			return reply(s, "%s OK Begin TLS negotiation now\r\n", tag)
+		case "NOOP":
+			err = reply(s, "%s OK NOOP completed\r\n", tag)
+		case "LOGOUT":
+			reply(s, "* BYE Logging out\r\n%s OK LOGOUT completed\r\n", tag)
+			return errQuit
+		default:
+			err = reply(s, "%s BAD STARTTLS required\r\n", tag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errTooMany
+}
+
+func (sess *Session) pop3(hostname string) error {
+	s := sess.Conn
+	if err := reply(s, "+OK %s POP3 server ready\r\n", hostname); err != nil {
+		return err
+	}
+	for len(sess.Commands) < maxCommands {
+		line, err := sess.readLine()
+		if err != nil {
+			return err
+		}
+		verb, _ := split(line)
+		switch verb {
+		case "CAPA":
+			err = reply(s, "+OK Capability list follows\r\nSTLS\r\n.\r\n")
+		case "STLS":
+			return reply(s, "+OK Begin TLS negotiation\r\n")
+		case "NOOP":
+			err = reply(s, "+OK\r\n")
+		case "QUIT":
+			reply(s, "+OK Bye\r\n")
+			return errQuit
+		default:
+			err = reply(s, "-ERR STLS required\r\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errTooMany
+}
+
+func (sess *Session) xmpp(hostname string) error {
+	s := sess.Conn
+	header, err := sess.readStreamHeader()
+	if err != nil {
+		return err
+	}
+	sess.Name = attr(header, "to")
+	ns := attr(header, "xmlns")
+	if ns == "" {
+		ns = "jabber:client"
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	if err := reply(s, "<?xml version='1.0'?><stream:stream from='%s' id='%s' version='1.0' "+
+		"xmlns='%s' xmlns:stream='http://etherx.jabber.org/streams'>"+
+		"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>"+
+		"</stream:features>", hostname, hex.EncodeToString(id), ns); err != nil {
+		return err
+	}
+
+	elem, err := sess.readElement()
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(elem), []byte("<starttls")) {
+		reply(s, "<stream:error><policy-violation xmlns='urn:ietf:params:xml:ns:xmpp-streams'/>"+
+			"</stream:error></stream:stream>")
+		return errRefused
+	}
+	return reply(s, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+}
+
+// XMPPProbe matches the opening of an XMPP stream, with or without the XML
+// declaration in front.
+var XMPPProbe = tease.ProbeFunc(func(b []byte) (tease.Result, int) {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	for _, prefix := range []string{"<?xml", "<stream:stream"} {
+		n := len(prefix)
+		if len(b) < n {
+			if bytes.HasPrefix([]byte(prefix), b) {
+				return tease.NeedMore, 0
+			}
+			continue
+		}
+		if string(b[:n]) == prefix {
+			return tease.Match, 0
+		}
+	}
+	return tease.NoMatch, 0
+})
+
+// Send a reply, committing the input read so far.
+func reply(s *tease.Server, format string, a ...interface{}) error {
+	_, err := s.WriteThrough([]byte(fmt.Sprintf(format, a...)))
+	return err
+}
+
+// Read the next line sent by the client and record it as a command.
+func (sess *Session) readLine() ([]byte, error) {
+	b, err := sess.readTo(0, '\n')
+	if err != nil {
+		return nil, err
+	}
+	sess.Commands = append(sess.Commands, b)
+	return b, nil
+}
+
+// Read the next XML element sent by the client and record it as a command.
+// Only empty elements are expected before the upgrade, so a start tag is
+// read along with its end tag.
+func (sess *Session) readElement() ([]byte, error) {
+	b, err := sess.readTo(0, '>')
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(b, []byte("/>")) {
+		end, err := sess.readTo(len(b), '>')
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, end...)
+	}
+	sess.Commands = append(sess.Commands, b)
+	return b, nil
+}
+
+// Read the stream header, along with the XML declaration in front of it, and
+// record it as a single command.
+func (sess *Session) readStreamHeader() ([]byte, error) {
+	if _, err := sess.Conn.Detect(XMPPProbe); err != nil {
+		return nil, err
+	}
+	var header []byte
+	for {
+		tag, err := sess.readTo(len(header), '>')
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, tag...)
+		tag = bytes.TrimSpace(tag)
+		if bytes.HasPrefix(tag, []byte("<stream:stream")) {
+			sess.Commands = append(sess.Commands, header)
+			return header, nil
+		}
+		if !bytes.HasPrefix(tag, []byte("<?xml")) {
+			return nil, errNoStream
+		}
+	}
+}
+
+// Read up to and including the next delim in the uncommitted input, of which
+// the first off bytes have already been read.
+func (sess *Session) readTo(off int, delim byte) ([]byte, error) {
+	s := sess.Conn
+	if _, err := s.Detect(tease.ProbeFunc(func(b []byte) (tease.Result, int) {
+		if bytes.IndexByte(b[off:], delim) >= 0 {
+			return tease.Match, 0
+		}
+		return tease.NeedMore, len(b) + 1
+	})); err != nil {
+		return nil, err
+	}
+	b := make([]byte, bytes.IndexByte(s.Buffered()[off:], delim)+1)
+	if _, err := io.ReadFull(s, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Split a command line into its upper cased verb and the argument.
+func split(line []byte) (verb, arg string) {
+	f := strings.Fields(string(line))
+	if len(f) == 0 {
+		return "", ""
+	}
+	verb = strings.ToUpper(f[0])
+	if len(f) > 1 {
+		arg = strings.Join(f[1:], " ")
+	}
+	return
+}
+
+// Value of an attribute within an XML tag.
+func attr(tag []byte, name string) string {
+	for _, q := range []string{"'", "\""} {
+		key := " " + name + "=" + q
+		i := bytes.Index(tag, []byte(key))
+		if i < 0 {
+			continue
+		}
+		v := tag[i+len(key):]
+		if j := bytes.Index(v, []byte(q)); j >= 0 {
+			return string(v[:j])
+		}
+	}
+	return ""
+}
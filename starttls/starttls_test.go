@@ -0,0 +1,285 @@
+package starttls
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+func TestXMPPProbe(t *testing.T) {
+	tests := []struct {
+		in     string
+		result tease.Result
+	}{
+		{"", tease.NeedMore},
+		{"  \r\n", tease.NeedMore},
+		{"<", tease.NeedMore},
+		{"\n<?x", tease.NeedMore},
+		{"<stream:str", tease.NeedMore},
+		{"<?xml version='1.0'?>", tease.Match},
+		{"  <stream:stream to='example.com'", tease.Match},
+		{"<html>", tease.NoMatch},
+		{"<?php", tease.NoMatch},
+		{"EHLO x", tease.NoMatch},
+	}
+	for _, tt := range tests {
+		r, need := XMPPProbe.Probe([]byte(tt.in))
+		if r != tt.result || need != 0 {
+			t.Errorf("Probe(%q) = %v, %d, want %v, 0", tt.in, r, need, tt.result)
+		}
+	}
+}
+
+// Run the dialogue against the client input, returning what the client was
+// sent.
+func negotiate(t *testing.T, p Protocol, in string) (*Session, string, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	defer b.Close()
+	a.SetDeadline(time.Now().Add(2 * time.Second))
+	go b.Write([]byte(in))
+	out := make(chan string, 1)
+	go func() {
+		got, _ := io.ReadAll(b)
+		out <- string(got)
+	}()
+	sess, err := Negotiate(tease.NewServer(a), p, "mx.example.com")
+	a.Close()
+	return sess, <-out, err
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol Protocol
+		in       string
+		out      string
+		sess     string
+		commands int
+		err      error
+	}{
+		{
+			name:     "smtp",
+			protocol: SMTP,
+			in:       "EHLO client.example.org\r\nMAIL FROM:<a@b>\r\nSTARTTLS\r\n\x16\x03\x01",
+			out: "220 mx.example.com ESMTP\r\n250-mx.example.com\r\n250 STARTTLS\r\n" +
+				"530 5.7.0 Must issue a STARTTLS command first\r\n220 2.0.0 Ready to start TLS\r\n",
+			sess:     "client.example.org",
+			commands: 3,
+		},
+		{
+			name:     "smtp quit",
+			protocol: SMTP,
+			in:       "HELO c\r\nQUIT\r\n",
+			out:      "220 mx.example.com ESMTP\r\n250 mx.example.com\r\n221 2.0.0 Bye\r\n",
+			err:      errQuit,
+		},
+		{
+			name:     "smtp too many commands",
+			protocol: SMTP,
+			in:       strings.Repeat("NOOP\r\n", maxCommands),
+			out:      "220 mx.example.com ESMTP\r\n" + strings.Repeat("250 2.0.0 OK\r\n", maxCommands),
+			err:      errTooMany,
+		},
+		{
+			name:     "imap",
+			protocol: IMAP,
+			in:       "a1 CAPABILITY\r\na2 LOGIN u p\r\nbad\r\na3 starttls\r\n",
+			out: "* OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED] mx.example.com ready\r\n" +
+				"* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED\r\na1 OK CAPABILITY completed\r\n" +
+				"a2 BAD STARTTLS required\r\n* BAD Invalid command\r\na3 OK Begin TLS negotiation now\r\n",
+			commands: 4,
+		},
+		{
+			name:     "pop3",
+			protocol: POP3,
+			in:       "CAPA\r\nUSER u\r\nSTLS\r\n",
+			out: "+OK mx.example.com POP3 server ready\r\n+OK Capability list follows\r\nSTLS\r\n.\r\n" +
+				"-ERR STLS required\r\n+OK Begin TLS negotiation\r\n",
+			commands: 3,
+		},
+		{
+			name:     "xmpp",
+			protocol: XMPP,
+			in: "<?xml version='1.0'?>\n<stream:stream to='example.com' xmlns='jabber:client' " +
+				"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>" +
+				"<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+			out:      "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+			sess:     "example.com",
+			commands: 2,
+		},
+		{
+			name:     "xmpp without starttls",
+			protocol: XMPP,
+			in:       "<stream:stream to='example.com'><auth/>",
+			out:      "</stream:error></stream:stream>",
+			err:      errRefused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, out, err := negotiate(t, tt.protocol, tt.in)
+			if err != tt.err {
+				t.Fatalf("Negotiate() error %v, want %v", err, tt.err)
+			}
+			if tt.protocol == XMPP {
+				if !strings.HasSuffix(out, tt.out) {
+					t.Errorf("client got %q", out)
+				}
+			} else if out != tt.out {
+				t.Errorf("client got %q, want %q", out, tt.out)
+			}
+			if err != nil {
+				return
+			}
+			if sess.Name != tt.sess || len(sess.Commands) != tt.commands {
+				t.Errorf("session name %q with %d commands", sess.Name, len(sess.Commands))
+			}
+			var replayed []byte
+			for _, cmd := range sess.Commands {
+				replayed = append(replayed, cmd...)
+			}
+			if !strings.HasPrefix(tt.in, string(replayed)) {
+				t.Errorf("commands %q", sess.Commands)
+			}
+		})
+	}
+}
+
+// The TLS handshake is teased once the client has been told to go ahead.
+func TestNegotiateThenTLS(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	defer a.Close()
+	a.SetDeadline(time.Now().Add(2 * time.Second))
+	go b.Write([]byte("a1 STARTTLS\r\n\x16\x03\x01\x00\x05"))
+	go io.Copy(io.Discard, b)
+	s := tease.NewServer(a)
+	if _, err := Negotiate(s, IMAP, "imap.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Detect(tease.TLS); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Buffered(); !bytes.HasPrefix(got, []byte{0x16, 3, 1}) {
+		t.Errorf("Buffered() = %q", got)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol Protocol
+		commands []string
+		replies  string
+		err      error
+	}{
+		{
+			name:     "smtp multiline",
+			protocol: SMTP,
+			commands: []string{"EHLO c\r\n", "STARTTLS\r\n"},
+			replies:  "220-backend ESMTP\r\n220 ready\r\n250-backend\r\n250-SIZE 0\r\n250 STARTTLS\r\n220 go ahead\r\n",
+		},
+		{
+			name:     "smtp refused",
+			protocol: SMTP,
+			commands: []string{"EHLO c\r\n", "STARTTLS\r\n"},
+			replies:  "220 backend\r\n250 backend\r\n454 4.7.0 TLS not available\r\n",
+			err:      errBackend,
+		},
+		{
+			name:     "smtp greeting refused",
+			protocol: SMTP,
+			commands: []string{"STARTTLS\r\n"},
+			replies:  "554 go away\r\n",
+			err:      errBackend,
+		},
+		{
+			name:     "imap tagged",
+			protocol: IMAP,
+			commands: []string{"a1 CAPABILITY\r\n", "a2 STARTTLS\r\n"},
+			replies: "* OK ready\r\n* CAPABILITY IMAP4rev1 STARTTLS\r\na1 OK done\r\n" +
+				"* NOTE a2 OK is not tagged\r\na2 OK begin\r\n",
+		},
+		{
+			name:     "imap refused",
+			protocol: IMAP,
+			commands: []string{"a1 STARTTLS\r\n"},
+			replies:  "* OK ready\r\na1 NO not now\r\n",
+			err:      errBackend,
+		},
+		{
+			name:     "pop3 capa",
+			protocol: POP3,
+			commands: []string{"CAPA\r\n", "STLS\r\n"},
+			replies:  "+OK ready\r\n+OK list\r\nUSER\r\nSTLS\r\n.\r\n+OK begin\r\n",
+		},
+		{
+			name:     "pop3 refused",
+			protocol: POP3,
+			commands: []string{"STLS\r\n"},
+			replies:  "+OK ready\r\n-ERR no\r\n",
+			err:      errBackend,
+		},
+		{
+			name:     "xmpp",
+			protocol: XMPP,
+			commands: []string{"<stream:stream to='example.com'>", "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"},
+			replies: "<?xml version='1.0'?><stream:stream id='1'>\n<stream:features>" +
+				"<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>" +
+				"<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'></proceed>",
+		},
+		{
+			name:     "xmpp failure",
+			protocol: XMPP,
+			commands: []string{"<stream:stream to='example.com'>", "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"},
+			replies:  "<stream:stream id='1'><stream:features></stream:features><failure/>",
+			err:      errBackend,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := &Session{Protocol: tt.protocol}
+			var want string
+			for _, c := range tt.commands {
+				sess.Commands = append(sess.Commands, []byte(c))
+				want += c
+			}
+			if tt.protocol == XMPP {
+				want = tt.commands[0] + tt.commands[len(tt.commands)-1]
+			}
+
+			a, b := net.Pipe()
+			defer b.Close()
+			a.SetDeadline(time.Now().Add(2 * time.Second))
+			// The TLS handshake of the backend follows its last reply
+			go b.Write([]byte(tt.replies + "\x16\x03\x03"))
+			sent := make(chan string, 1)
+			go func() {
+				got, _ := io.ReadAll(b)
+				sent <- string(got)
+			}()
+
+			err := sess.Prepare(a)
+			if err != tt.err {
+				t.Errorf("Prepare() = %v, want %v", err, tt.err)
+			}
+			if err == nil {
+				// Nothing past the last reply was read
+				rest := make([]byte, 3)
+				if _, err := io.ReadFull(a, rest); err != nil || string(rest) != "\x16\x03\x03" {
+					t.Errorf("left %q, %v", rest, err)
+				}
+			}
+			a.Close()
+			if got := <-sent; err == nil && got != want {
+				t.Errorf("backend got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -9,4 +9,6 @@ var (
 	errHasWriten   = errors.New("tease: cannot read after write without pipe mode")
 	errAlreadyPipe = errors.New("tease: connection already in pipe mode")
 	errMaxBuffer   = errors.New("tease: request exceeded MaxBuffer, closing connection")
+	errNoMatch     = errors.New("tease: no probe matched the connection")
+	errPending     = errors.New("tease: cannot write through with buffered output")
 )
@@ -0,0 +1,248 @@
+package tls
+
+import (
+	"errors"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/internal/wire"
+)
+
+const (
+	recordTypeHandshake    = 0x16
+	recordHeaderLen        = 5
+	maxRecordLen           = 16384 + 2048
+	handshakeTypeHello     = 0x01
+	handshakeHeaderLen     = 4
+	maxClientHelloLen      = 1 << 16
+	extServerName          = 0
+	extSupportedGroups     = 10
+	extECPointFormats      = 11
+	extSignatureAlgorithms = 13
+	extALPN                = 16
+	extSessionTicket       = 35
+	extSupportedVersions   = 43
+	extKeyShare            = 51
+)
+
+var (
+	// Returned by Parse when more records are needed
+	ErrIncomplete = errors.New("tls: incomplete ClientHello")
+	errNotTLS     = errors.New("tls: not a TLS handshake")
+	errMalformed  = errors.New("tls: malformed ClientHello")
+)
+
+// ClientHello holds the fields of a parsed TLS ClientHello message.  GREASE
+// values are kept as sent by the client.
+type ClientHello struct {
+	// Record layer version of the first record
+	RecordVersion uint16
+
+	// Legacy version field of the ClientHello
+	Version            uint16
+	Random             []byte
+	SessionID          []byte
+	CipherSuites       []uint16
+	CompressionMethods []uint8
+
+	// Extension types in the order sent by the client
+	Extensions []uint16
+
+	// Host name from the server_name extension
+	ServerName string
+
+	// Protocols from the application_layer_protocol_negotiation extension
+	ALPN []string
+
+	// Versions from the supported_versions extension
+	SupportedVersions []uint16
+
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+
+	// Groups of the key shares offered in the key_share extension
+	KeyShareGroups []uint16
+
+	// Set when the session_ticket extension is present, with or without a
+	// ticket to resume.
+	SessionTicket bool
+
+	// The handshake message, reassembled from the records
+	Raw []byte
+}
+
+// Parse reads a ClientHello out of the TLS records at the start of b.  If the
+// records end before the handshake message is complete, ErrIncomplete is
+// returned.
+func Parse(b []byte) (*ClientHello, error) {
+	hello, _, err := parse(b)
+	return hello, err
+}
+
+// Reassemble the handshake message from the records.  When incomplete, need
+// is the number of bytes to buffer before trying again.
+func parse(b []byte) (hello *ClientHello, need int, err error) {
+	var msg []byte
+	var recordVersion uint16
+	for off := 0; ; {
+		hdr := b[off:]
+		if len(hdr) > 0 && hdr[0] != recordTypeHandshake || len(hdr) > 1 && hdr[1] != 0x03 {
+			return nil, 0, errNotTLS
+		}
+		if len(hdr) < recordHeaderLen {
+			// The length which follows may still rule the record out
+			return nil, len(b) + 1, ErrIncomplete
+		}
+		n := int(hdr[3])<<8 | int(hdr[4])
+		if n == 0 || n > maxRecordLen {
+			return nil, 0, errNotTLS
+		}
+		if off == 0 {
+			recordVersion = uint16(hdr[1])<<8 | uint16(hdr[2])
+		}
+		off += recordHeaderLen
+		if len(b) < off+n {
+			return nil, off + n, ErrIncomplete
+		}
+		msg = append(msg, b[off:off+n]...)
+		off += n
+
+		if len(msg) < handshakeHeaderLen {
+			continue
+		}
+		if msg[0] != handshakeTypeHello {
+			return nil, 0, errNotTLS
+		}
+		l := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		if l > maxClientHelloLen {
+			return nil, 0, errMalformed
+		}
+		if len(msg) >= handshakeHeaderLen+l {
+			msg = msg[:handshakeHeaderLen+l]
+			break
+		}
+	}
+
+	hello, err = parseHello(msg)
+	if hello != nil {
+		hello.RecordVersion = recordVersion
+	}
+	return
+}
+
+// Parse the fields out of the reassembled handshake message.
+func parseHello(msg []byte) (*ClientHello, error) {
+	h := &ClientHello{Raw: msg}
+	r := &wire.Reader{B: msg[handshakeHeaderLen:]}
+
+	h.Version = r.U16()
+	h.Random = r.Bytes(32)
+	h.SessionID = vec8(r).B
+
+	suites := vec16(r)
+	for !suites.Empty() {
+		h.CipherSuites = append(h.CipherSuites, suites.U16())
+	}
+	h.CompressionMethods = vec8(r).B
+	if r.Bad || suites.Bad {
+		return nil, errMalformed
+	}
+
+	// Extensions are optional in very old clients
+	if r.Empty() {
+		return h, nil
+	}
+
+	exts := vec16(r)
+	for !exts.Empty() {
+		typ := exts.U16()
+		data := vec16(exts)
+		if exts.Bad {
+			return nil, errMalformed
+		}
+		h.Extensions = append(h.Extensions, typ)
+		if !h.parseExtension(typ, data) {
+			return nil, errMalformed
+		}
+	}
+	if r.Bad || exts.Bad {
+		return nil, errMalformed
+	}
+	return h, nil
+}
+
+// Fill in the fields of a known extension, returns false when malformed.
+func (h *ClientHello) parseExtension(typ uint16, r *wire.Reader) bool {
+	switch typ {
+	case extServerName:
+		names := vec16(r)
+		for !names.Empty() {
+			nameType := names.U8()
+			name := vec16(names)
+			if nameType == 0 && h.ServerName == "" {
+				h.ServerName = string(name.B)
+			}
+		}
+		return !names.Bad
+
+	case extALPN:
+		protos := vec16(r)
+		for !protos.Empty() {
+			h.ALPN = append(h.ALPN, string(vec8(protos).B))
+		}
+		return !protos.Bad
+
+	case extSupportedVersions:
+		versions := vec8(r)
+		for !versions.Empty() {
+			h.SupportedVersions = append(h.SupportedVersions, versions.U16())
+		}
+		return !versions.Bad
+
+	case extSupportedGroups:
+		groups := vec16(r)
+		for !groups.Empty() {
+			h.SupportedGroups = append(h.SupportedGroups, groups.U16())
+		}
+		return !groups.Bad
+
+	case extECPointFormats:
+		h.ECPointFormats = vec8(r).B
+		return !r.Bad
+
+	case extSignatureAlgorithms:
+		algs := vec16(r)
+		for !algs.Empty() {
+			h.SignatureAlgorithms = append(h.SignatureAlgorithms, algs.U16())
+		}
+		return !algs.Bad
+
+	case extKeyShare:
+		shares := vec16(r)
+		for !shares.Empty() {
+			h.KeyShareGroups = append(h.KeyShareGroups, shares.U16())
+			vec16(shares)
+		}
+		return !shares.Bad
+
+	case extSessionTicket:
+		h.SessionTicket = true
+	}
+	return true
+}
+
+// Probe matches a connection once a complete and well formed ClientHello has
+// been buffered.
+var Probe = tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+	_, need, err := parse(b)
+	return err == nil, need, err
+})
+
+// Read waits for a complete ClientHello on the teaser and parses it.  No
+// input is consumed, so the teaser can be piped afterwards as usual.
+func Read(s *tease.Server) (*ClientHello, error) {
+	if _, err := s.Detect(Probe); err != nil {
+		return nil, err
+	}
+	return Parse(s.Buffered())
+}
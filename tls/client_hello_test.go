@@ -0,0 +1,190 @@
+package tls
+
+import (
+	ctls "crypto/tls"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Capture the first record sent by a crypto/tls client with the config.
+func clientHelloRecord(t *testing.T, config *ctls.Config) []byte {
+	t.Helper()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	b.SetDeadline(time.Now().Add(2 * time.Second))
+	go ctls.Client(a, config).Handshake()
+
+	hdr := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(b, hdr); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	if _, err := io.ReadFull(b, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(hdr, body...)
+}
+
+// Split the handshake message of a record into records of at most n bytes.
+func fragment(record []byte, n int) []byte {
+	var out []byte
+	for msg := record[recordHeaderLen:]; len(msg) > 0; {
+		l := n
+		if l > len(msg) {
+			l = len(msg)
+		}
+		out = append(out, record[0], record[1], record[2], byte(l>>8), byte(l))
+		out = append(out, msg[:l]...)
+		msg = msg[l:]
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	record := clientHelloRecord(t, &ctls.Config{
+		ServerName: "www.example.com",
+		NextProtos: []string{"h2", "http/1.1"},
+		MinVersion: ctls.VersionTLS12,
+	})
+	msg := record[recordHeaderLen:]
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"single record", record},
+		{"trailing data", append(append([]byte{}, record...), 0x17, 0x03, 0x03)},
+		{"one byte records", fragment(record, 1)},
+		{"three byte records", fragment(record, 3)},
+		{"header split across records", fragment(record, 2)},
+		{"two records", fragment(record, len(msg)/2+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.ServerName != "www.example.com" || !reflect.DeepEqual(h.ALPN, []string{"h2", "http/1.1"}) {
+				t.Errorf("server name %q, ALPN %q", h.ServerName, h.ALPN)
+			}
+			if h.RecordVersion>>8 != 3 || h.Version != ctls.VersionTLS12 || len(h.Random) != 32 {
+				t.Errorf("record version %#x, version %#x, random %x", h.RecordVersion, h.Version, h.Random)
+			}
+			if len(h.CipherSuites) == 0 || len(h.SupportedGroups) == 0 || len(h.SignatureAlgorithms) == 0 ||
+				len(h.KeyShareGroups) == 0 || h.SupportedVersions[0] != ctls.VersionTLS13 {
+				t.Errorf("parsed %+v", h)
+			}
+			if string(h.Raw) != string(msg) {
+				t.Errorf("raw message differs")
+			}
+		})
+	}
+}
+
+// Every truncation of the records asks for more, without asking for bytes
+// past the end of the records.
+func TestParseTruncated(t *testing.T) {
+	record := clientHelloRecord(t, &ctls.Config{ServerName: "example.com"})
+	for _, in := range [][]byte{record, fragment(record, 7)} {
+		for i := 0; i < len(in); i++ {
+			_, need, err := parse(in[:i])
+			if err != ErrIncomplete || need <= i || need > len(in) {
+				t.Fatalf("parse(%d of %d bytes) = %d, %v", i, len(in), need, err)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	record := clientHelloRecord(t, &ctls.Config{ServerName: "example.com"})
+	modify := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, record...))
+	}
+	tests := []struct {
+		name string
+		in   []byte
+		err  error
+	}{
+		{"http", []byte("GET / HTTP/1.1\r\n"), errNotTLS},
+		{"application data record", []byte{0x17, 0x03, 0x03, 0x00, 0x10}, errNotTLS},
+		{"alert record", []byte{0x15, 0x03}, errNotTLS},
+		{"sslv2 version", []byte{0x16, 0x02, 0x00}, errNotTLS},
+		{"empty record", []byte{0x16, 0x03, 0x01, 0x00, 0x00}, errNotTLS},
+		{"oversized record", []byte{0x16, 0x03, 0x01, 0x48, 0x01}, errNotTLS},
+		{"server hello", modify(func(b []byte) []byte { b[5] = 0x02; return b }), errNotTLS},
+		{
+			name: "oversized client hello",
+			in:   []byte{0x16, 0x03, 0x01, 0x00, 0x04, handshakeTypeHello, 0x01, 0x00, 0x01},
+			err:  errMalformed,
+		},
+		{
+			name: "non-handshake record after the first",
+			in:   append(fragment(record, 10)[:15], 0x17, 0x03, 0x03, 0x00, 0x0a),
+			err:  errNotTLS,
+		},
+		{
+			// The message ends within the cipher suites
+			name: "short message",
+			in: append(append([]byte{0x16, 0x03, 0x01, 0x00, 43, handshakeTypeHello, 0x00, 0x00, 39, 0x03, 0x03},
+				make([]byte, 32)...), 0x00, 0x00, 0x10, 0x13, 0x01),
+			err: errMalformed,
+		},
+		{
+			// Extensions announced longer than what is left
+			name: "extensions overflow",
+			in: modify(func(b []byte) []byte {
+				// Skip the version, random, session ID, cipher suites and
+				// compression methods to the extensions length
+				off := recordHeaderLen + handshakeHeaderLen + 2 + 32
+				off += 1 + int(b[off])
+				off += 2 + (int(b[off])<<8 | int(b[off+1]))
+				off += 1 + int(b[off])
+				b[off]++
+				return b
+			}),
+			err: errMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, need, err := parse(tt.in); err != tt.err || need != 0 {
+				t.Errorf("parse() = %d, %v, want 0, %v", need, err, tt.err)
+			}
+		})
+	}
+}
+
+// A ClientHello trickling in one byte at a time is read once complete.
+func TestRead(t *testing.T) {
+	record := fragment(clientHelloRecord(t, &ctls.Config{ServerName: "example.com"}), 100)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	a.SetReadDeadline(time.Now().Add(2 * time.Second))
+	go func() {
+		for i := range record {
+			if _, err := b.Write(record[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+
+	s := tease.NewServer(a)
+	h, err := Read(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ServerName != "example.com" {
+		t.Errorf("server name %q", h.ServerName)
+	}
+	if got := s.Buffered(); string(got) != string(record) {
+		t.Errorf("buffered %d bytes, want %d", len(got), len(record))
+	}
+}
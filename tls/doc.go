@@ -0,0 +1,13 @@
+/*
+Package tls detects TLS connections on a teaser and parses the ClientHello out
+of the replay buffer.
+
+The ClientHello is parsed from the raw TLS records buffered by tease.Server,
+including handshakes which are split over several records, without consuming
+any input.  After inspection the teaser can still be piped into crypto/tls or
+passed through to a backend untouched.
+
+Clients offering post-quantum key shares send ClientHellos of well over a
+kilobyte, so the MaxBuffer of the teaser should be raised accordingly.
+*/
+package tls
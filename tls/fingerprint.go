@@ -0,0 +1,205 @@
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Fingerprint holds the JA3 and JA4 fingerprints of a ClientHello along with
+// the raw strings they are hashed from.
+type Fingerprint struct {
+	// MD5 hash of JA3Raw
+	JA3 string
+
+	// Version,Ciphers,Extensions,Groups,PointFormats in decimal
+	JA3Raw string
+
+	// Fingerprint in the JA4 a_b_c form
+	JA4 string
+
+	// The JA4_r form, with the sorted cipher and extension lists in place of
+	// their hashes
+	JA4Raw string
+}
+
+// Fingerprint computes the JA3 and JA4 fingerprints of the ClientHello.  GREASE
+// values are ignored as the specifications require.
+func (h *ClientHello) Fingerprint() *Fingerprint {
+	f := &Fingerprint{}
+
+	ciphers := noGrease(h.CipherSuites)
+	exts := noGrease(h.Extensions)
+	groups := noGrease(h.SupportedGroups)
+	formats := make([]uint16, len(h.ECPointFormats))
+	for i, p := range h.ECPointFormats {
+		formats[i] = uint16(p)
+	}
+
+	f.JA3Raw = fmt.Sprintf("%d,%s,%s,%s,%s", h.Version, joinDec(ciphers),
+		joinDec(exts), joinDec(groups), joinDec(formats))
+	sum := md5.Sum([]byte(f.JA3Raw))
+	f.JA3 = hex.EncodeToString(sum[:])
+
+	// JA4_a: transport, version, sni, counts and alpn
+	sni := "i"
+	if h.ServerName != "" {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(h), sni,
+		min99(len(ciphers)), min99(len(exts)), ja4ALPN(h.ALPN))
+
+	// JA4_b: sorted ciphers
+	sortedCiphers := joinHex(sorted(ciphers))
+
+	// JA4_c: sorted extensions without sni and alpn, then signature algorithms
+	var cExts []uint16
+	for _, e := range exts {
+		if e != extServerName && e != extALPN {
+			cExts = append(cExts, e)
+		}
+	}
+	c := joinHex(sorted(cExts))
+	if algs := noGrease(h.SignatureAlgorithms); len(algs) > 0 {
+		c += "_" + joinHex(algs)
+	}
+
+	f.JA4 = a + "_" + ja4Hash(sortedCiphers, len(ciphers)) + "_" + ja4Hash(c, len(cExts))
+	f.JA4Raw = a + "_" + sortedCiphers + "_" + c
+	return f
+}
+
+// ReadFingerprint waits for a complete ClientHello on the teaser and returns
+// its fingerprints.  No input is consumed.
+func ReadFingerprint(s *tease.Server) (*Fingerprint, error) {
+	hello, err := Read(s)
+	if err != nil {
+		return nil, err
+	}
+	return hello.Fingerprint(), nil
+}
+
+// Fingerprinted returns a probe matching the ClientHellos for which the
+// function returns true.  Combined with Mux.Deny it makes a blocklist which
+// refuses clients before a TLS handshake is spent on them.
+func Fingerprinted(match func(f *Fingerprint) bool) tease.Probe {
+	return tease.ParseProbe(ErrIncomplete, func(b []byte) (bool, int, error) {
+		hello, need, err := parse(b)
+		return err == nil && match(hello.Fingerprint()), need, err
+	})
+}
+
+// Blocklist returns a probe matching the ClientHellos with any of the given
+// JA3 or JA4 fingerprints.  The comparison ignores case, as the ALPN
+// characters of a JA4 keep the case the client sent.
+func Blocklist(fingerprints ...string) tease.Probe {
+	set := make(map[string]bool, len(fingerprints))
+	for _, f := range fingerprints {
+		set[strings.ToLower(f)] = true
+	}
+	return Fingerprinted(func(f *Fingerprint) bool {
+		return set[strings.ToLower(f.JA3)] || set[strings.ToLower(f.JA4)]
+	})
+}
+
+// GREASE values are of the form 0x?a?a with both bytes equal, RFC 8701.
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func noGrease(list []uint16) []uint16 {
+	out := make([]uint16, 0, len(list))
+	for _, v := range list {
+		if !isGrease(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func sorted(list []uint16) []uint16 {
+	out := append([]uint16(nil), list...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func joinDec(list []uint16) string {
+	s := make([]string, len(list))
+	for i, v := range list {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
+
+func joinHex(list []uint16) string {
+	s := make([]string, len(list))
+	for i, v := range list {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+// Truncated SHA256 used in JA4, all zeros for an empty list.
+func ja4Hash(s string, n int) string {
+	if n == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Highest version offered, from supported_versions when present.
+func ja4Version(h *ClientHello) string {
+	v := h.Version
+	for _, sv := range noGrease(h.SupportedVersions) {
+		if sv > v {
+			v = sv
+		}
+	}
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+// First and last characters of the first ALPN value.
+func ja4ALPN(protos []string) string {
+	if len(protos) == 0 || protos[0] == "" {
+		return "00"
+	}
+	p := protos[0]
+	first, last := p[0], p[len(p)-1]
+	if !isAlnum(first) || !isAlnum(last) {
+		x := hex.EncodeToString([]byte(p))
+		return x[:1] + x[len(x)-1:]
+	}
+	return string([]byte{first, last})
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,158 @@
+package tls
+
+import (
+	ctls "crypto/tls"
+	"strings"
+	"testing"
+
+	tease "github.com/pschou/go-tease"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name   string
+		hello  *ClientHello
+		ja3Raw string
+		ja3    string
+		ja4Raw string
+		ja4    string
+	}{
+		{
+			// Example of the JA3 README, with GREASE added
+			name: "ja3",
+			hello: &ClientHello{
+				Version:         0x0301,
+				CipherSuites:    []uint16{0x0a0a, 47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+				Extensions:      []uint16{0, 10, 0xfafa, 11},
+				SupportedGroups: []uint16{0x2a2a, 23, 24, 25},
+				ECPointFormats:  []uint8{0},
+				ServerName:      "example.com",
+			},
+			ja3Raw: "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3:    "ada70206e40642a3e4461f35503241d5",
+			ja4Raw: "t10d120300_0004,0005,000a,0013,002f,0032,0035,0038,c009,c00a,c013,c014_000a,000b",
+		},
+		{
+			// Second example of the JA3 README, without extensions
+			name: "ja3 without extensions",
+			hello: &ClientHello{
+				Version:      0x0301,
+				CipherSuites: []uint16{4, 5, 10, 9, 100, 98, 3, 6, 19, 18, 99},
+			},
+			ja3Raw: "769,4-5-10-9-100-98-3-6-19-18-99,,,",
+			ja3:    "de350869b8c85de67a350c8d186f11e6",
+			ja4Raw: "t10i110000_0003,0004,0005,0006,0009,000a,0012,0013,0062,0063,0064_",
+			ja4:    "t10i110000_3609b414f052_000000000000",
+		},
+		{
+			// Chrome example of the JA4 technical details, with GREASE in the
+			// lists and the extensions shuffled as Chrome does
+			name: "ja4",
+			hello: &ClientHello{
+				Version: 0x0303,
+				CipherSuites: []uint16{0x4a4a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+					0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+				Extensions: []uint16{0x1a1a, 0x0023, 0x4469, 0x0010, 0x0000, 0x000b, 0x0005, 0x0015, 0x002d,
+					0x0033, 0x000a, 0x001b, 0x0017, 0xff01, 0x0012, 0x002b, 0x000d, 0x3a3a},
+				ServerName:          "www.example.com",
+				ALPN:                []string{"h2", "http/1.1"},
+				SupportedVersions:   []uint16{0x7a7a, 0x0304, 0x0303},
+				SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+			},
+			ja4: "t13d1516h2_8daaf6152771_e5627efa2ab1",
+			ja4Raw: "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_" +
+				"0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_" +
+				"0403,0804,0401,0503,0805,0501,0806,0601",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.hello.Fingerprint()
+			if tt.ja3Raw != "" && (f.JA3Raw != tt.ja3Raw || f.JA3 != tt.ja3) {
+				t.Errorf("JA3 %s, %s\nwant %s, %s", f.JA3, f.JA3Raw, tt.ja3, tt.ja3Raw)
+			}
+			if f.JA4Raw != tt.ja4Raw || tt.ja4 != "" && f.JA4 != tt.ja4 {
+				t.Errorf("JA4 %s, %s\nwant %s, %s", f.JA4, f.JA4Raw, tt.ja4, tt.ja4Raw)
+			}
+		})
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+	tests := []struct {
+		protos []string
+		want   string
+	}{
+		{nil, "00"},
+		{[]string{""}, "00"},
+		{[]string{"h2", "http/1.1"}, "h2"},
+		{[]string{"http/1.1"}, "h1"},
+		{[]string{"H2"}, "H2"},
+		{[]string{"x"}, "xx"},
+		{[]string{"\xab\xcd"}, "ad"},
+		{[]string{"\xab"}, "ab"},
+		{[]string{"a\x01"}, "61"},
+	}
+	for _, tt := range tests {
+		if got := ja4ALPN(tt.protos); got != tt.want {
+			t.Errorf("ja4ALPN(%q) = %q, want %q", tt.protos, got, tt.want)
+		}
+	}
+}
+
+func TestJA4Version(t *testing.T) {
+	tests := []struct {
+		version  uint16
+		versions []uint16
+		want     string
+	}{
+		{0x0303, []uint16{0x0a0a, 0x0304, 0x0303}, "13"},
+		{0x0303, nil, "12"},
+		{0x0301, nil, "10"},
+		{0x0300, nil, "s3"},
+		{0x0303, []uint16{0xfafa}, "12"},
+		{0x7f00, nil, "00"},
+	}
+	for _, tt := range tests {
+		if got := ja4Version(&ClientHello{Version: tt.version, SupportedVersions: tt.versions}); got != tt.want {
+			t.Errorf("ja4Version(%#x, %#x) = %q, want %q", tt.version, tt.versions, got, tt.want)
+		}
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	// An upper case ALPN shows in the JA4 as is
+	record := clientHelloRecord(t, &ctls.Config{ServerName: "example.com", NextProtos: []string{"H2"}})
+	h, err := Parse(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := h.Fingerprint()
+	if !strings.Contains(f.JA4, "H2_") {
+		t.Fatalf("JA4 %s", f.JA4)
+	}
+
+	tests := []struct {
+		name string
+		list []string
+		want tease.Result
+	}{
+		{"ja3", []string{f.JA3}, tease.Match},
+		{"ja3 upper case", []string{strings.ToUpper(f.JA3)}, tease.Match},
+		{"ja4", []string{f.JA4}, tease.Match},
+		{"ja4 lower case", []string{strings.ToLower(f.JA4)}, tease.Match},
+		{"ja4 upper case", []string{strings.ToUpper(f.JA4)}, tease.Match},
+		{"among others", []string{"t13d1516h2_8daaf6152771_e5627efa2ab1", f.JA3}, tease.Match},
+		{"others", []string{"t13d1516h2_8daaf6152771_e5627efa2ab1", "ada70206e40642a3e4461f35503241d5"}, tease.NoMatch},
+		{"raw form", []string{f.JA4Raw}, tease.NoMatch},
+		{"empty", nil, tease.NoMatch},
+	}
+	for _, tt := range tests {
+		if r, _ := Blocklist(tt.list...).Probe(record); r != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, r, tt.want)
+		}
+	}
+	if r, _ := Blocklist(f.JA3).Probe(record[:10]); r != tease.NeedMore {
+		t.Errorf("partial ClientHello: %v", r)
+	}
+}
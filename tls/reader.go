@@ -0,0 +1,13 @@
+package tls
+
+import "github.com/pschou/go-tease/internal/wire"
+
+// Vector with an 8 bit length prefix
+func vec8(r *wire.Reader) *wire.Reader {
+	return r.Sub(int(r.U8()))
+}
+
+// Vector with a 16 bit length prefix
+func vec16(r *wire.Reader) *wire.Reader {
+	return r.Sub(int(r.U16()))
+}
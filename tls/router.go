@@ -0,0 +1,242 @@
+package tls
+
+import (
+	ctls "crypto/tls"
+	"net"
+	"strings"
+	"sync"
+
+	tease "github.com/pschou/go-tease"
+)
+
+// Action is what the Router does with a connection matching a route.
+type Action int
+
+const (
+	// Pass the connection through to a backend without terminating TLS.
+	Passthrough Action = iota
+
+	// Terminate TLS locally and deliver the connection to a listener.
+	Terminate
+
+	// Refuse the connection with a TLS alert.
+	Reject
+)
+
+// TLS alert descriptions commonly used to reject a connection.
+const (
+	AlertHandshakeFailure      uint8 = 40
+	AlertAccessDenied          uint8 = 49
+	AlertProtocolVersion       uint8 = 70
+	AlertInternalError         uint8 = 80
+	AlertUnrecognizedName      uint8 = 112
+	AlertNoApplicationProtocol uint8 = 120
+)
+
+// Route is an entry of the Router table.
+type Route struct {
+	// Server name pattern, empty to match any name.
+	ServerName string
+
+	// Protocol which must be offered in the client ALPN list, empty to match
+	// any client.
+	ALPN string
+
+	Action Action
+
+	// Backend address for Passthrough
+	Addr string
+
+	// Configuration for Terminate
+	Config *ctls.Config
+
+	// Alert description sent for Reject
+	Alert uint8
+
+	l *tease.Listener
+}
+
+// Router is a tease.Handler which picks a route for TLS connections based on
+// the server name and ALPN list in the ClientHello.  A route either passes the
+// connection through to a backend untouched, terminates TLS locally, or
+// rejects the connection with a TLS alert.
+//
+// Server name patterns are either an exact host name, or a wildcard of the
+// form "*.example.com" which matches a single label in place of the star.
+// Exact names take precedence over wildcards, and wildcards over routes
+// without a name.  Among those, routes requiring an ALPN protocol take
+// precedence, then the routes added first.
+type Router struct {
+	// Backend address used when no route matches.  When empty, unmatched
+	// connections are rejected with an unrecognized_name alert.
+	Default string
+
+	// Optional dial function to connect to the backends.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// Address reported by the listeners of the Terminate routes, typically
+	// the address of the Mux the router is registered on.  It must be set
+	// before the routes are added.
+	Addr net.Addr
+
+	// PROXY protocol version of the header sent to passthrough backends,
+	// zero for none.  A v2 header carries the server name and the first
+	// offered ALPN protocol.
+	ProxyProtocol int
+
+	mu     sync.RWMutex
+	routes []*Route
+}
+
+// Add routes the server names matching pattern to the backend address.
+func (r *Router) Add(pattern, addr string) {
+	r.Passthrough(pattern, "", addr)
+}
+
+// Passthrough sends the matching connections to the backend address without
+// terminating TLS.
+func (r *Router) Passthrough(pattern, alpn, addr string) {
+	r.AddRoute(&Route{ServerName: pattern, ALPN: alpn, Action: Passthrough, Addr: addr})
+}
+
+// Terminate returns a listener which receives the matching connections with
+// TLS terminated locally using config.  The handshake is done on the first
+// read or write, as with crypto/tls.Server.
+func (r *Router) Terminate(pattern, alpn string, config *ctls.Config) net.Listener {
+	route := &Route{ServerName: pattern, ALPN: alpn, Action: Terminate, Config: config}
+	r.AddRoute(route)
+	return route.l
+}
+
+// Reject refuses the matching connections with the given alert.
+func (r *Router) Reject(pattern, alpn string, alert uint8) {
+	r.AddRoute(&Route{ServerName: pattern, ALPN: alpn, Action: Reject, Alert: alert})
+}
+
+// AddRoute adds an entry to the route table.
+func (r *Router) AddRoute(route *Route) {
+	route.ServerName = strings.ToLower(strings.TrimSuffix(route.ServerName, "."))
+	if route.Action == Terminate && route.l == nil {
+		route.l = tease.NewListener(r.Addr)
+	}
+	r.mu.Lock()
+	r.routes = append(r.routes, route)
+	r.mu.Unlock()
+}
+
+// Close closes the listeners of the Terminate routes.  A Mux closes the
+// router along with itself.
+func (r *Router) Close() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, route := range r.routes {
+		if route.l != nil {
+			route.l.Close()
+		}
+	}
+	return nil
+}
+
+// Lookup returns the route for a ClientHello, or nil when none matches.
+func (r *Router) Lookup(hello *ClientHello) *Route {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var best *Route
+	bestScore := 0
+	for _, route := range r.routes {
+		score := nameScore(route.ServerName, name)
+		if score == 0 {
+			continue
+		}
+		if route.ALPN != "" {
+			if !hasProto(hello.ALPN, route.ALPN) {
+				continue
+			}
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = route, score
+		}
+	}
+	if best == nil && r.Default != "" {
+		best = &Route{Action: Passthrough, Addr: r.Default}
+	}
+	return best
+}
+
+// Rank how specific a pattern matches the name, zero if it does not.
+func nameScore(pattern, name string) int {
+	switch {
+	case pattern == "":
+		return 1
+	case pattern == name:
+		return 5
+	case strings.HasPrefix(pattern, "*."):
+		// A wildcard replaces exactly one label
+		if i := strings.IndexByte(name, '.'); i > 0 && name[i:] == pattern[1:] {
+			return 3
+		}
+	}
+	return 0
+}
+
+func hasProto(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeTease reads the ClientHello and acts on the route picked for it.
+func (r *Router) ServeTease(s *tease.Server) {
+	hello, err := Read(s)
+	if err != nil {
+		s.Abort()
+		return
+	}
+
+	route := r.Lookup(hello)
+	if route == nil {
+		route = &Route{Action: Reject, Alert: AlertUnrecognizedName}
+	}
+
+	switch route.Action {
+	case Passthrough:
+		f := &tease.Forwarder{
+			Addr:          route.Addr,
+			Dial:          r.Dial,
+			ProxyProtocol: r.ProxyProtocol,
+			Protocol:      "tls",
+		}
+		if hello.ServerName != "" {
+			f.TLVs = append(f.TLVs, tease.ProxyTLV{Type: tease.PP2TypeAuthority, Value: []byte(hello.ServerName)})
+		}
+		if len(hello.ALPN) > 0 {
+			f.TLVs = append(f.TLVs, tease.ProxyTLV{Type: tease.PP2TypeALPN, Value: []byte(hello.ALPN[0])})
+		}
+		f.ServeTease(s)
+	case Terminate:
+		if err := s.Pipe(); err != nil {
+			s.Abort()
+			return
+		}
+		route.l.Deliver(ctls.Server(s, route.Config))
+	default:
+		s.Write(alertRecord(route.Alert))
+		s.Pipe()
+		s.Close()
+	}
+}
+
+// Fatal alert in a TLS 1.2 record, which every client version understands
+// before the handshake is done.
+func alertRecord(desc uint8) []byte {
+	if desc == 0 {
+		desc = AlertHandshakeFailure
+	}
+	return []byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, desc}
+}
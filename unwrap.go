@@ -0,0 +1,22 @@
+package tease
+
+import "net"
+
+// BaseConn walks down a stack of wrapped connections, through the NetConn or
+// Unwrap methods of teasers and crypto/tls connections, and returns the
+// connection at the bottom of the stack.
+func BaseConn(conn net.Conn) net.Conn {
+	for {
+		var next net.Conn
+		switch c := conn.(type) {
+		case interface{ NetConn() net.Conn }:
+			next = c.NetConn()
+		case interface{ Unwrap() net.Conn }:
+			next = c.Unwrap()
+		}
+		if next == nil || next == conn {
+			return conn
+		}
+		conn = next
+	}
+}
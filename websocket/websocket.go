@@ -0,0 +1,123 @@
+/*
+Package websocket detects the WebSocket opening handshake of RFC 6455 in the
+teaser buffer, so that connections can be routed on their subprotocol, path
+or origin before the handshake is answered.
+*/
+package websocket
+
+import (
+	"errors"
+	"strings"
+
+	tease "github.com/pschou/go-tease"
+	"github.com/pschou/go-tease/http1"
+)
+
+var errNotWebSocket = errors.New("websocket: not a WebSocket handshake")
+
+// Handshake is the client opening handshake.  The path of the request target
+// is given by the Path method of the request.
+type Handshake struct {
+	*http1.Request
+
+	// Origin header sent by browsers
+	Origin string
+
+	// Subprotocols offered in Sec-WebSocket-Protocol, in order of preference
+	Protocols []string
+
+	// Sec-WebSocket-Key and Sec-WebSocket-Version
+	Key     string
+	Version string
+}
+
+// Parse reads the opening handshake at the start of b.  http1.ErrIncomplete
+// is returned while the request head is not complete.
+func Parse(b []byte) (*Handshake, error) {
+	req, err := http1.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	return newHandshake(req)
+}
+
+func newHandshake(req *http1.Request) (*Handshake, error) {
+	h := req.Header
+	if req.Method != "GET" || req.ProtoMinor < 1 ||
+		!req.HasToken("Upgrade", "websocket") ||
+		!req.HasToken("Connection", "upgrade") ||
+		h.Get("Sec-Websocket-Key") == "" {
+		return nil, errNotWebSocket
+	}
+	hs := &Handshake{
+		Request: req,
+		Origin:  h.Get("Origin"),
+		Key:     h.Get("Sec-Websocket-Key"),
+		Version: h.Get("Sec-Websocket-Version"),
+	}
+	for _, v := range h.Values("Sec-Websocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				hs.Protocols = append(hs.Protocols, p)
+			}
+		}
+	}
+	return hs, nil
+}
+
+// Matching returns a probe matching the handshakes for which the function
+// returns true.
+func Matching(match func(h *Handshake) bool) tease.Probe {
+	return http1.Matching(func(req *http1.Request) bool {
+		hs, err := newHandshake(req)
+		return err == nil && match(hs)
+	})
+}
+
+// Probe matches any WebSocket opening handshake.
+var Probe = Matching(func(*Handshake) bool { return true })
+
+// Subprotocol returns a probe matching the handshakes offering any of the
+// given subprotocols.
+func Subprotocol(protos ...string) tease.Probe {
+	return Matching(func(hs *Handshake) bool {
+		for _, offered := range hs.Protocols {
+			for _, p := range protos {
+				if offered == p {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// Route returns a probe matching the handshakes on a path starting with the
+// prefix which offer the subprotocol.  An empty subprotocol matches any
+// handshake on the path.
+func Route(pathPrefix, subprotocol string) tease.Probe {
+	return Matching(func(hs *Handshake) bool {
+		if !strings.HasPrefix(hs.Path(), pathPrefix) {
+			return false
+		}
+		if subprotocol == "" {
+			return true
+		}
+		for _, offered := range hs.Protocols {
+			if offered == subprotocol {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Read waits for the opening handshake on the teaser and parses it.  No
+// input is consumed.
+func Read(s *tease.Server) (*Handshake, error) {
+	req, err := http1.Read(s)
+	if err != nil {
+		return nil, err
+	}
+	return newHandshake(req)
+}
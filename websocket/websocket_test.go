@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+
+	tease "github.com/pschou/go-tease"
+)
+
+const handshake = "GET /chat?room=1 HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"Sec-WebSocket-Protocol: chat, superchat\r\n" +
+	"Sec-WebSocket-Protocol: mqtt\r\n" +
+	"Origin: http://example.com\r\n\r\n"
+
+func TestParse(t *testing.T) {
+	hs, err := Parse([]byte(handshake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Path() != "/chat" || hs.Method != "GET" || hs.Origin != "http://example.com" ||
+		hs.Key != "dGhlIHNhbXBsZSBub25jZQ==" || hs.Version != "13" {
+		t.Errorf("parsed %+v with path %q", hs, hs.Path())
+	}
+	if want := []string{"chat", "superchat", "mqtt"}; !reflect.DeepEqual(hs.Protocols, want) {
+		t.Errorf("protocols %q, want %q", hs.Protocols, want)
+	}
+
+	for _, in := range []string{
+		"GET /chat HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"POST /chat HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: x\r\n\r\n",
+		"GET /chat HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n",
+	} {
+		if _, err := Parse([]byte(in)); err != errNotWebSocket {
+			t.Errorf("Parse(%q) = %v", in, err)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		probe  tease.Probe
+		result tease.Result
+	}{
+		{Route("/chat", ""), tease.Match},
+		{Route("/chat", "mqtt"), tease.Match},
+		{Route("/chat", "stomp"), tease.NoMatch},
+		{Route("/api", ""), tease.NoMatch},
+		{Subprotocol("stomp", "superchat"), tease.Match},
+		{Subprotocol("stomp"), tease.NoMatch},
+	}
+	for i, tt := range tests {
+		if r, _ := tt.probe.Probe([]byte(handshake)); r != tt.result {
+			t.Errorf("probe %d: %v, want %v", i, r, tt.result)
+		}
+	}
+}